@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"golang.org/x/perf/benchstat"
+)
+
+// adaptiveOptions configures adaptive iteration counts. In adaptive mode, each
+// package is run for at least the configured count of iterations and then until
+// the confidence interval of every one of its benchmarks is narrow enough, or
+// until one of the limits is reached. Adaptive mode is enabled if ciTarget is
+// non-zero.
+type adaptiveOptions struct {
+	// ciTarget is the target relative half-width of the 95% confidence
+	// interval of the mean of each benchmark's metrics, e.g. 0.01 for ±1%.
+	ciTarget float64
+	// maxCount is the maximum number of iterations per package.
+	maxCount int
+	// maxTime, if non-zero, is the maximum time spent iterating on a package.
+	maxTime time.Duration
+}
+
+func (o adaptiveOptions) enabled() bool {
+	return o.ciTarget > 0
+}
+
+// tQuantiles holds the 0.975 quantiles of Student's t-distribution for 1 to
+// 30 degrees of freedom.
+var tQuantiles = [...]float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// tQuantile returns the 0.975 quantile of Student's t-distribution with df
+// degrees of freedom, which is used to compute 95% confidence intervals.
+func tQuantile(df int) float64 {
+	if df < 1 {
+		return math.Inf(1)
+	}
+	if df <= len(tQuantiles) {
+		return tQuantiles[df-1]
+	}
+	// Approaches the normal distribution's 1.960 for large df.
+	return 1.960 + 2.4/float64(df)
+}
+
+// relativeCI returns the half-width of the 95% confidence interval of the mean
+// of the metric's values, with outliers removed, relative to the mean.
+func relativeCI(m *benchstat.Metrics) float64 {
+	n := len(m.RValues)
+	if n < 2 {
+		return math.Inf(1)
+	}
+	var mean float64
+	for _, v := range m.RValues {
+		mean += v
+	}
+	mean /= float64(n)
+	var ss float64
+	for _, v := range m.RValues {
+		ss += (v - mean) * (v - mean)
+	}
+	if ss == 0 {
+		return 0
+	}
+	if mean == 0 {
+		return math.Inf(1)
+	}
+	stddev := math.Sqrt(ss / float64(n-1))
+	return tQuantile(n-1) * stddev / math.Sqrt(float64(n)) / math.Abs(mean)
+}
+
+// widestCI returns the widest relative confidence interval across all metrics
+// of all benchmarks of the package in the collection, which must be split by
+// package. It returns false if the collection has no results for the package.
+func widestCI(c *benchstat.Collection, pkg string) (float64, bool) {
+	group := "pkg:" + pkg
+	var widest float64
+	found := false
+	for k, m := range c.Metrics {
+		if k.Group != group {
+			continue
+		}
+		found = true
+		if ci := relativeCI(m); ci > widest {
+			widest = ci
+		}
+	}
+	return widest, found
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"golang.org/x/perf/benchstat"
+)
+
+func TestRelativeCI(t *testing.T) {
+	for _, tc := range []struct {
+		values []float64
+		exp    float64
+	}{
+		{nil, math.Inf(1)},
+		{[]float64{10}, math.Inf(1)},
+		{[]float64{10, 10, 10}, 0},
+		{[]float64{0, 0}, 0},
+		// mean=10, stddev=sqrt(4/3), n=4: 3.182 * 1.1547 / 2 / 10.
+		{[]float64{9, 11, 9, 11}, 0.1837},
+	} {
+		ci := relativeCI(&benchstat.Metrics{RValues: tc.values})
+		if math.IsInf(tc.exp, 1) {
+			if !math.IsInf(ci, 1) {
+				t.Errorf("%v: expected +Inf, found %f", tc.values, ci)
+			}
+			continue
+		}
+		if math.Abs(ci-tc.exp) > 1e-3 {
+			t.Errorf("%v: expected %f, found %f", tc.values, tc.exp, ci)
+		}
+	}
+}
@@ -1,12 +1,24 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
 	"hash/fnv"
+	"io"
+	"io/fs"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/pkg/errors"
 )
@@ -15,25 +27,265 @@ const bazelRunScript = `#!/bin/bash
 SCRIPT_PATH="$(realpath "$0")"
 BAZEL_DIR="${SCRIPT_PATH}.bazel"
 RUNFILES_DIR="${BAZEL_DIR}/%[1]s.runfiles"
-"${BAZEL_DIR}/%[1]s" "$@"
+exec "${BAZEL_DIR}/%[1]s" "$@"
 `
 
+// goPackage is a Go package, as described by `go list -json`.
+type goPackage struct {
+	ImportPath   string
+	Dir          string
+	TestGoFiles  []string
+	XTestGoFiles []string
+	Module       *struct {
+		Path string
+		Dir  string
+	}
+}
+
+// moduleDir returns the root directory of the package's module, from which its
+// test binary is built. It is empty for packages outside of modules.
+func (p goPackage) moduleDir() string {
+	if p.Module == nil {
+		return ""
+	}
+	return p.Module.Dir
+}
+
 // expandPackages expands the package filter into all of the packages that it
-// references using `go list`.
-func expandPackages(pkgFilter []string) ([]string, error) {
-	args := []string{"go", "list"}
-	args = append(args, pkgFilter...)
-	pkgs, err := capture(args...)
+// references using `go list`. Patterns like "./...", which do not match the
+// packages of nested modules, are extended with the nested modules of the Go
+// workspace or, outside of a workspace, of the directory tree. In a workspace,
+// all patterns are expanded from the current directory. Otherwise, the
+// patterns that refer to directories are expanded from the root of the module
+// that contains them.
+func expandPackages(ctx context.Context, pkgFilter []string) ([]goPackage, error) {
+	work, err := capture(ctx, "go", "env", "GOWORK")
 	if err != nil {
 		return nil, errors.Wrap(err, "expanding packages")
 	}
-	return strings.Split(pkgs, "\n"), nil
+	inWork := work != "" && work != "off"
+	var modDirs []string
+	if inWork {
+		out, err := capture(ctx, "go", "list", "-m", "-f", "{{.Dir}}")
+		if err != nil {
+			return nil, errors.Wrap(err, "listing workspace modules")
+		}
+		modDirs = strings.Split(out, "\n")
+	}
+
+	byDir := map[string][]string{"": nil}
+	dirs := []string{""}
+	for _, pattern := range pkgFilter {
+		patterns := []string{pattern}
+		if !inWork {
+			modDirs = findModules(pattern)
+		}
+		patterns = append(patterns, nestedModulePatterns(pattern, modDirs)...)
+		for _, p := range patterns {
+			dir, rel := "", p
+			if !inWork {
+				dir, rel = patternModule(p)
+			}
+			if _, ok := byDir[dir]; !ok {
+				dirs = append(dirs, dir)
+			}
+			byDir[dir] = append(byDir[dir], rel)
+		}
+	}
+
+	var pkgs []goPackage
+	seen := make(map[string]struct{})
+	for _, dir := range dirs {
+		if len(byDir[dir]) == 0 {
+			continue
+		}
+		args := append([]string{"go", "list", "-json=ImportPath,Dir,TestGoFiles,XTestGoFiles,Module"}, byDir[dir]...)
+		out, err := captureIn(ctx, dir, args...)
+		if err != nil {
+			return nil, errors.Wrap(err, "expanding packages")
+		}
+		dec := json.NewDecoder(strings.NewReader(out))
+		for {
+			var pkg goPackage
+			if err := dec.Decode(&pkg); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, errors.Wrap(err, "expanding packages")
+			}
+			if _, ok := seen[pkg.ImportPath]; !ok {
+				seen[pkg.ImportPath] = struct{}{}
+				pkgs = append(pkgs, pkg)
+			}
+		}
+	}
+	return pkgs, nil
+}
+
+// patternModule returns the root directory of the module that contains the
+// directory of a package pattern, like "./sub/mod/...", along with the
+// pattern relative to that directory. Patterns that are import paths, and
+// patterns in the module of the current directory, are left as they are.
+func patternModule(pattern string) (dir, rel string) {
+	if !build.IsLocalImport(pattern) && !filepath.IsAbs(pattern) {
+		return "", pattern
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", pattern
+	}
+	abs := pattern
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(cwd, pattern)
+	}
+	mod := findModuleRoot(strings.TrimSuffix(abs, "/..."))
+	if mod == "" || mod == findModuleRoot(cwd) {
+		return "", pattern
+	}
+	r, err := filepath.Rel(mod, abs)
+	if err != nil {
+		return "", pattern
+	}
+	return mod, "./" + filepath.ToSlash(r)
+}
+
+// recursivePatternDir returns the absolute directory of a recursive pattern
+// that refers to a directory, like "./pkg/...".
+func recursivePatternDir(pattern string) (string, bool) {
+	if !build.IsLocalImport(pattern) && !filepath.IsAbs(pattern) {
+		return "", false
+	}
+	if !strings.HasSuffix(pattern, "/...") {
+		return "", false
+	}
+	dir, err := filepath.Abs(strings.TrimSuffix(pattern, "..."))
+	return dir, err == nil
+}
+
+// nestedModulePatterns returns patterns that match the packages of the modules
+// nested in the directory of a recursive pattern, which the pattern itself
+// does not match.
+func nestedModulePatterns(pattern string, modDirs []string) []string {
+	dir, ok := recursivePatternDir(pattern)
+	if !ok {
+		return nil
+	}
+	var patterns []string
+	for _, modDir := range modDirs {
+		rel, err := filepath.Rel(dir, modDir)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		if filepath.IsAbs(pattern) {
+			patterns = append(patterns, filepath.Join(modDir, "..."))
+		} else {
+			patterns = append(patterns, "./"+filepath.ToSlash(filepath.Join(strings.TrimSuffix(pattern, "..."), rel))+"/...")
+		}
+	}
+	return patterns
+}
+
+// findModules returns the directories of the modules in the directory tree of
+// a recursive pattern. Like the go command, it skips vendor and testdata
+// directories, and directories starting with a dot or an underscore.
+func findModules(pattern string) []string {
+	dir, ok := recursivePatternDir(pattern)
+	if !ok {
+		return nil
+	}
+	var modDirs []string
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !d.IsDir() {
+			if d.Name() == "go.mod" {
+				modDirs = append(modDirs, filepath.Dir(path))
+			}
+			return nil
+		}
+		if name := d.Name(); path != dir && (name == "vendor" || name == "testdata" ||
+			strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return modDirs
+}
+
+// findModuleRoot returns the closest directory, starting at dir, that contains
+// a go.mod file, or an empty string if there is none.
+func findModuleRoot(dir string) string {
+	for d := dir; ; d = filepath.Dir(d) {
+		if fileExists(filepath.Join(d, "go.mod")) {
+			return d
+		}
+		if filepath.Dir(d) == d {
+			return ""
+		}
+	}
+}
+
+// filterBenchPackages returns the packages with benchmarks that match the
+// top-level part of the -test.bench pattern, as determined by parsing their
+// test files, so that no test binaries are built for the others.
+func filterBenchPackages(pkgs []goPackage, runPattern string) ([]goPackage, error) {
+	top, _ := splitBenchPattern(runPattern)
+	re, err := regexp.Compile(top)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing --run pattern")
+	}
+	var filtered []goPackage
+	for _, pkg := range pkgs {
+		if hasBenchmarks(pkg, re) {
+			filtered = append(filtered, pkg)
+		}
+	}
+	return filtered, nil
 }
 
+// hasBenchmarks returns whether the test files of the package declare a
+// benchmark whose name matches the regexp. If a test file cannot be parsed,
+// the package is assumed to have benchmarks, so that building it reports the
+// problem.
+func hasBenchmarks(pkg goPackage, re *regexp.Regexp) bool {
+	fset := token.NewFileSet()
+	for _, name := range append(append([]string(nil), pkg.TestGoFiles...), pkg.XTestGoFiles...) {
+		f, err := parser.ParseFile(fset, filepath.Join(pkg.Dir, name), nil, parser.SkipObjectResolution)
+		if err != nil {
+			return true
+		}
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if ok && fn.Recv == nil && isBenchmarkName(fn.Name.Name) && re.MatchString(fn.Name.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isBenchmarkName returns whether the name is that of a benchmark function,
+// like BenchmarkFoo but not Benchmarker, following the testing package's rules.
+func isBenchmarkName(name string) bool {
+	const prefix = "Benchmark"
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	if len(name) == len(prefix) {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(name[len(prefix):])
+	return !unicode.IsLower(r)
+}
+
+// benchdiffDir is the directory, relative to the repository root, in which
+// benchdiff stores all of its binaries, artifacts, and results.
+const benchdiffDir = "benchdiff"
+
 // testDir returns the directory to store benchdiff artifacts and binaries for
 // specified git ref.
 func testDir(ref string) string {
-	return filepath.Join("benchdiff", ref)
+	return filepath.Join(benchdiffDir, ref)
 }
 
 // testArtifactsDir returns the directory to store benchdiff artifacts for
@@ -51,94 +303,330 @@ func hash(s []string) string {
 	return strconv.Itoa(int(u))
 }
 
+// testBinNaming identifies the scheme that test binaries are named by, see
+// pkgToTestBin. It is part of the key of binary directories, so that
+// directories whose binaries were named by an earlier scheme are not reused
+// alongside ones named by the current scheme.
+const testBinNaming = "naming=2"
+
 // testArtifactsDir returns the directory to store benchdiff binaries for
-// specified git ref.
-func testBinDir(ref string, pkgFilter []string) string {
-	return filepath.Join(testDir(ref), "bin", hash(pkgFilter))
+// specified git ref. Only the packages with benchmarks matching the run
+// pattern are built, so the pattern is part of the directory's key unless it
+// matches all benchmarks. Binaries built with Bazel, if bazel is not nil, are
+// keyed by its configs and startup flags, which can change what is built.
+func testBinDir(ref string, pkgFilter []string, runPattern string, bazel *bazelOptions) string {
+	key := append([]string{testBinNaming}, pkgFilter...)
+	if runPattern != "" && runPattern != "." {
+		key = append(key, "run="+runPattern)
+	}
+	if bazel != nil {
+		key = append(key, "bazel")
+		for _, c := range bazel.configs {
+			key = append(key, "bazel-config="+c)
+		}
+		for _, f := range bazel.startupFlags {
+			key = append(key, "bazel-startup-flag="+f)
+		}
+	}
+	return filepath.Join(testDir(ref), "bin", hash(key))
+}
+
+// binManifestFile is the name of the file in each binary directory that
+// records the package and ref of each test binary.
+const binManifestFile = "bins.json"
+
+// testBinInfo describes the source of a test binary.
+type testBinInfo struct {
+	Pkg string `json:"pkg"` // import path
+	Ref string `json:"ref"`
+	// Target is the Bazel target that the binary was built from, if any.
+	Target string `json:"target,omitempty"`
+}
+
+// binManifest maps the names of the test binaries in a binary directory to
+// their sources.
+type binManifest map[string]testBinInfo
+
+// readBinManifest reads the manifest of the binary directory. Directories that
+// predate manifests have none, in which case a nil manifest is returned.
+func readBinManifest(binDir string) (binManifest, error) {
+	b, err := os.ReadFile(filepath.Join(binDir, binManifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var m binManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, errors.Wrapf(err, "parsing %s manifest", binDir)
+	}
+	return m, nil
+}
+
+// writeBinManifest writes the manifest of the binary directory.
+func writeBinManifest(binDir string, m binManifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(binDir, binManifestFile), b, 0644)
 }
 
-// pkgToTestBin translates a Go package name into a test binary name.
+// pkgToTestBin translates a Go package name into a test binary name, like
+// github.com_cockroachdb_cockroach_pkg_util_log. The translation is lossless:
+// percent signs and underscores are escaped before slashes are turned into
+// underscores, so that the names of different packages do not collide.
 func pkgToTestBin(pkg string) string {
-	// Strip github.com prefix.
-	f := strings.TrimPrefix(pkg, "github.com")
-	// Turn forward-slashes into underscores.
-	f = strings.ReplaceAll(f, "/", "_")
-	// Trim leading underscores.
-	return strings.TrimLeft(f, "_")
+	f := strings.ReplaceAll(pkg, "%", "%25")
+	f = strings.ReplaceAll(f, "_", "%5F")
+	return strings.ReplaceAll(f, "/", "_")
 }
 
-// testBinToPkg translates a test binary name to a Go package name. This
-// tranlation does not round-trip, but comes close enough.
+// testBinToPkg translates a test binary name back to its Go package name. The
+// names of Bazel test binaries can be suffixed by ":<target>". The binary
+// directory's manifest, if any, takes precedence; see benchSuite.testPkg.
 func testBinToPkg(bin string) string {
-	return strings.ReplaceAll(bin, "_", "/")
+	bin, _, _ = strings.Cut(bin, ":")
+	pkg, err := url.PathUnescape(strings.ReplaceAll(bin, "_", "/"))
+	if err != nil {
+		// Not a name produced by pkgToTestBin.
+		return strings.ReplaceAll(bin, "_", "/")
+	}
+	return pkg
 }
 
 // buildTestBinWithGo builds a test binary, using Go directly, for the specified
-// package and moves it to the destination directory if successful.
-func buildTestBinWithGo(pkg, dst string) (string, bool, error) {
-	dstFile := pkgToTestBin(pkg) // cockroachdb_cockroach_pkg_util_log
+// package into the destination directory. The binary is built from the root
+// of the package's module.
+func buildTestBinWithGo(ctx context.Context, pkg goPackage, dst string) (string, bool, error) {
+	dstFile := pkgToTestBin(pkg.ImportPath) // github.com_cockroachdb_cockroach_pkg_util_log
+	dstPath, err := filepath.Abs(filepath.Join(dst, dstFile))
+	if err != nil {
+		return "", false, err
+	}
 	// Capture to silence warnings from pkgs with no test files.
-	if _, err := capture("go", "test", "-c", "-o", dstFile, pkg); err != nil {
+	if _, err := captureIn(ctx, pkg.moduleDir(), "go", "test", "-c", "-o", dstPath, pkg.ImportPath); err != nil {
 		return "", false, errors.Wrap(err, "building test binary")
 	}
 
 	// If there were no tests in the package, no file will have been created.
-	if _, err := os.Stat(dstFile); err != nil {
+	if _, err := os.Stat(dstPath); err != nil {
 		if os.IsNotExist(err) {
 			return "", false, nil
 		}
 		return "", false, errors.Wrap(err, "looking for test binary")
 	}
-	if err := spawn("mv", dstFile, filepath.Join(dst, dstFile)); err != nil {
-		return "", false, errors.Wrap(err, "moving test binary")
-	}
 	return dstFile, true, nil
 }
 
-// buildTestBinWithBazel builds a test binary, using Bazel, for the specified
-// package. It creates an executable script inplace of a binary that will invoke
-// the test binary with the correct runfiles, stored in a `<dst>.bazel`
-// directory alongside it.
-func buildTestBinWithBazel(pkg, dst string) (string, bool, error) {
-	dstBin := pkgToTestBin(pkg) // cockroachdb_cockroach_pkg_util_log
-	dstBazelDir := filepath.Join(dst, dstBin+".bazel")
+// bazelOptions configures how test binaries are built with Bazel.
+type bazelOptions struct {
+	// startupFlags are passed to bazel ahead of the command, like
+	// --output_base.
+	startupFlags []string
+	// configs are passed to each command with --config.
+	configs []string
+}
 
-	relPkg := strings.TrimPrefix(pkg, "github.com/cockroachdb/cockroach/")
-	pathList := strings.Split(relPkg, string(filepath.Separator)) // ['pkg','util','log']
-	last := pathList[len(pathList)-1]                             // 'log'
-	// `bazel build //pkg/util/log:log_test`.
-	if _, err := capture("bazel", "build", "//"+relPkg+":"+last+"_test"); err != nil {
-		return "", false, errors.Wrap(err, "building test binary")
+// command returns the arguments of a bazel command.
+func (o *bazelOptions) command(cmd string, args ...string) []string {
+	c := append([]string{"bazel"}, o.startupFlags...)
+	c = append(c, cmd)
+	for _, config := range o.configs {
+		c = append(c, "--config="+config)
 	}
+	return append(c, args...)
+}
 
-	// `_bazel/bin/pkg/util/log/log_test_`.
-	outDir := append([]string{"_bazel", "bin"}, pathList...)
-	outDir = append(outDir, last+"_test_")
+// bazelTestTarget is a go_test target of a Go package.
+type bazelTestTarget struct {
+	pkg   string // Go import path
+	label string // like //pkg/util/log:log_test
+}
 
-	// `_bazel/bin/pkg/util/log/log_test_/log_test`.
-	srcBin := filepath.Join(filepath.Join(outDir...), last+"_test")
-	// `_bazel/bin/pkg/util/log/log_test_/log_test.runfiles`.
-	srcRunfilesDir := filepath.Join(filepath.Join(outDir...), filepath.Base(srcBin)+".runfiles")
+// buildTestBinsWithBazel builds the test binaries of the specified packages
+// using Bazel. The go_test targets of the packages are discovered with `bazel
+// query`, and their executables are located with `bazel cquery`. For each
+// target, a script that runs the test binary with the correct runfiles is
+// written to the destination directory, and the binary and its runfiles are
+// stored in a `<script>.bazel` directory next to it. The scripts are returned
+// along with their packages and targets.
+func buildTestBinsWithBazel(
+	ctx context.Context, pkgs []goPackage, dst string, o *bazelOptions, progress func(done, total int),
+) (binManifest, error) {
+	info, err := capture(ctx, o.command("info", "workspace", "execution_root")...)
+	if err != nil {
+		return nil, errors.Wrap(err, "locating bazel workspace")
+	}
+	workspace := procInfoField(strings.NewReader(info), "workspace")
+	execRoot := procInfoField(strings.NewReader(info), "execution_root")
 
-	// If there were no tests in the package, no test binary file will have been
-	// created.
-	if _, err := os.Stat(srcBin); err != nil {
-		if os.IsNotExist(err) {
-			return "", false, nil
+	targets, err := queryBazelTestTargets(ctx, pkgs, workspace, o)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	labels := make([]string, len(targets))
+	for i, t := range targets {
+		labels[i] = t.label
+	}
+	if _, err := capture(ctx, o.command("build", labels...)...); err != nil {
+		return nil, errors.Wrap(err, "building test binaries")
+	}
+
+	// Packages can have more than one go_test target.
+	perPkg := make(map[string]int)
+	for _, t := range targets {
+		perPkg[t.pkg]++
+	}
+	bins := make(binManifest, len(targets))
+	for i, t := range targets {
+		progress(i, len(targets))
+		files, err := capture(ctx, o.command("cquery", "--output=files", t.label)...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "locating test binary of %s", t.label)
+		}
+		srcBin := bazelTestBinary(strings.Split(files, "\n"), t.label)
+		if srcBin == "" {
+			return nil, errors.Errorf("no test binary among the outputs of %s", t.label)
+		}
+		// Output paths are relative to the execution root.
+		if !filepath.IsAbs(srcBin) {
+			srcBin = filepath.Join(execRoot, srcBin)
+		}
+		dstBin := pkgToTestBin(t.pkg) // github.com_cockroachdb_cockroach_pkg_util_log
+		if perPkg[t.pkg] > 1 {
+			// Import paths cannot contain colons, so the package can still
+			// be determined from the name.
+			dstBin += ":" + bazelTargetName(t.label)
+		}
+		if err := installBazelTestBin(ctx, srcBin, dst, dstBin); err != nil {
+			return nil, err
+		}
+		bins[dstBin] = testBinInfo{Pkg: t.pkg, Target: t.label}
+	}
+	progress(len(targets), len(targets))
+	return bins, nil
+}
+
+// queryBazelTestTargets finds the go_test targets of the specified packages,
+// whose directories are mapped to Bazel packages relative to the workspace.
+// Directories without a BUILD file are not Bazel packages, which would fail
+// the query, so they are skipped.
+func queryBazelTestTargets(
+	ctx context.Context, pkgs []goPackage, workspace string, o *bazelOptions,
+) ([]bazelTestTarget, error) {
+	byBazelPkg := make(map[string]string)
+	var patterns []string
+	for _, pkg := range pkgs {
+		if !hasBazelBuildFile(pkg.Dir) {
+			continue
+		}
+		bazelPkg, err := bazelPackage(workspace, pkg.Dir)
+		if err != nil {
+			return nil, err
+		}
+		byBazelPkg[bazelPkg] = pkg.ImportPath
+		patterns = append(patterns, "//"+bazelPkg+":all")
+	}
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	query := fmt.Sprintf("kind(go_test, set(%s))", strings.Join(patterns, " "))
+	out, err := capture(ctx, o.command("query", query)...)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying test targets")
+	}
+	var targets []bazelTestTarget
+	for _, label := range strings.Split(out, "\n") {
+		if label == "" {
+			continue
+		}
+		pkg, ok := byBazelPkg[bazelLabelPackage(label)]
+		if !ok {
+			return nil, errors.Errorf("test target %s outside of the queried packages", label)
+		}
+		targets = append(targets, bazelTestTarget{pkg: pkg, label: label})
+	}
+	return targets, nil
+}
+
+// hasBazelBuildFile returns whether the directory has a BUILD file, which makes
+// it a Bazel package.
+func hasBazelBuildFile(dir string) bool {
+	for _, name := range []string{"BUILD.bazel", "BUILD"} {
+		if fi, err := os.Stat(filepath.Join(dir, name)); err == nil && !fi.IsDir() {
+			return true
 		}
-		return "", false, errors.Wrap(err, "looking for test binary")
 	}
+	return false
+}
+
+// bazelPackage returns the Bazel package of the directory, like
+// "pkg/util/log", which is its path relative to the workspace.
+func bazelPackage(workspace, dir string) (string, error) {
+	rel, err := filepath.Rel(workspace, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", errors.Errorf("package directory %s is outside of the bazel workspace %s", dir, workspace)
+	}
+	if rel == "." {
+		return "", nil
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// bazelLabelPackage returns the package of a label, like "pkg/util/log" for
+// "//pkg/util/log:log_test".
+func bazelLabelPackage(label string) string {
+	label = strings.TrimPrefix(label, "@")
+	label = strings.TrimPrefix(label, "//")
+	pkg, _, _ := strings.Cut(label, ":")
+	return pkg
+}
+
+// bazelTargetName returns the name of the target of a label, like "log_test"
+// for "//pkg/util/log:log_test".
+func bazelTargetName(label string) string {
+	if i := strings.LastIndex(label, ":"); i >= 0 {
+		return label[i+1:]
+	}
+	return filepath.Base(label)
+}
+
+// bazelTestBinary picks the test binary from the outputs of a go_test target,
+// which is the output named after the target.
+func bazelTestBinary(files []string, label string) string {
+	name := bazelTargetName(label)
+	for _, f := range files {
+		if f := strings.TrimSpace(f); filepath.Base(f) == name || filepath.Base(f) == name+".exe" {
+			return f
+		}
+	}
+	return ""
+}
+
+// installBazelTestBin copies a test binary built by Bazel and its runfiles
+// into the destination directory, along with a script to run it.
+func installBazelTestBin(ctx context.Context, srcBin, dst, dstBin string) error {
+	dstBazelDir := filepath.Join(dst, dstBin+".bazel")
 	if err := os.Mkdir(dstBazelDir, 0755); err != nil {
-		return "", false, errors.Wrap(err, "creating bazel binary directory")
+		return errors.Wrap(err, "creating bazel binary directory")
+	}
+	args := []string{"cp", "-rL", srcBin}
+	// `<bin>.runfiles`, if the binary has runfiles.
+	if srcRunfilesDir := srcBin + ".runfiles"; fileExists(srcRunfilesDir) {
+		args = append(args, srcRunfilesDir)
 	}
-	if err := spawn("cp", "-rL", srcBin, srcRunfilesDir, dstBazelDir); err != nil {
-		return "", false, errors.Wrap(err, "copying binary and bazel runfiles")
+	if err := spawn(ctx, append(args, dstBazelDir)...); err != nil {
+		return errors.Wrap(err, "copying binary and bazel runfiles")
 	}
 	runScript := fmt.Sprintf(bazelRunScript, filepath.Base(srcBin))
 	if err := writeExecutableScript(runScript, filepath.Join(dst, dstBin)); err != nil {
-		return "", false, errors.Wrap(err, "writing bazel binary script")
+		return errors.Wrap(err, "writing bazel binary script")
 	}
-	return dstBin, true, nil
+	return nil
 }
 
 func writeExecutableScript(script, path string) error {
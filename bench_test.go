@@ -6,7 +6,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -32,3 +36,300 @@ func BenchmarkDummy(b *testing.B) {
 		})
 	}
 }
+
+func TestBazelOptionsCommand(t *testing.T) {
+	o := &bazelOptions{startupFlags: []string{"--output_base=/tmp/b"}, configs: []string{"ci", "race"}}
+	exp := []string{"bazel", "--output_base=/tmp/b", "build", "--config=ci", "--config=race", "//pkg:pkg_test"}
+	if c := o.command("build", "//pkg:pkg_test"); !reflect.DeepEqual(c, exp) {
+		t.Errorf("expected %q, found %q", exp, c)
+	}
+}
+
+func TestBazelPackage(t *testing.T) {
+	testCases := []struct {
+		dir string
+		exp string
+		err bool
+	}{
+		{dir: "/ws/pkg/util/log", exp: "pkg/util/log"},
+		{dir: "/ws", exp: ""},
+		{dir: "/other/pkg", err: true},
+		{dir: "/wsx/pkg", err: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.dir, func(t *testing.T) {
+			pkg, err := bazelPackage("/ws", tc.dir)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected error, found %q", pkg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pkg != tc.exp {
+				t.Errorf("expected %q, found %q", tc.exp, pkg)
+			}
+		})
+	}
+}
+
+func TestQueryBazelTestTargets(t *testing.T) {
+	dir := t.TempDir()
+	ws := filepath.Join(dir, "ws")
+	for _, d := range []string{"a", "b"} {
+		if err := os.MkdirAll(filepath.Join(ws, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(ws, "a", "BUILD.bazel"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A fake bazel records its arguments and lists the test target of a.
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	argsFile := filepath.Join(dir, "args")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$@\" > '%s'\necho //a:a_test\n", argsFile)
+	if err := os.WriteFile(filepath.Join(bin, "bazel"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(filepath.ListSeparator)+os.Getenv("PATH"))
+
+	// Package b has no BUILD file, so it is left out of the query.
+	pkgs := []goPackage{
+		{ImportPath: "example.com/a", Dir: filepath.Join(ws, "a")},
+		{ImportPath: "example.com/b", Dir: filepath.Join(ws, "b")},
+	}
+	targets, err := queryBazelTestTargets(context.Background(), pkgs, ws, &bazelOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []bazelTestTarget{{pkg: "example.com/a", label: "//a:a_test"}}
+	if !reflect.DeepEqual(targets, exp) {
+		t.Errorf("expected %+v, found %+v", exp, targets)
+	}
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expArgs := "query kind(go_test, set(//a:all))\n"; string(args) != expArgs {
+		t.Errorf("expected bazel %q, found %q", expArgs, args)
+	}
+}
+
+func TestBazelLabels(t *testing.T) {
+	testCases := []struct {
+		label, pkg, name string
+	}{
+		{label: "//pkg/util/log:log_test", pkg: "pkg/util/log", name: "log_test"},
+		{label: "//:root_test", pkg: "", name: "root_test"},
+		{label: "@//pkg:pkg_test", pkg: "pkg", name: "pkg_test"},
+	}
+	for _, tc := range testCases {
+		if pkg := bazelLabelPackage(tc.label); pkg != tc.pkg {
+			t.Errorf("%s: expected package %q, found %q", tc.label, tc.pkg, pkg)
+		}
+		if name := bazelTargetName(tc.label); name != tc.name {
+			t.Errorf("%s: expected name %q, found %q", tc.label, tc.name, name)
+		}
+	}
+
+	files := []string{
+		"bazel-out/k8-fastbuild/bin/pkg/util/log/log_test_/log_test.runfiles_manifest",
+		"bazel-out/k8-fastbuild/bin/pkg/util/log/log_test_/log_test",
+	}
+	exp := files[1]
+	if f := bazelTestBinary(files, "//pkg/util/log:log_test"); f != exp {
+		t.Errorf("expected %q, found %q", exp, f)
+	}
+	if f := bazelTestBinary(files, "//pkg/util/log:other_test"); f != "" {
+		t.Errorf("expected no binary, found %q", f)
+	}
+}
+
+func TestPkgToTestBin(t *testing.T) {
+	testCases := []struct {
+		pkg, bin string
+	}{
+		{pkg: "github.com/cockroachdb/cockroach/pkg/util/log", bin: "github.com_cockroachdb_cockroach_pkg_util_log"},
+		{pkg: "example.com/a_b", bin: "example.com_a%5Fb"},
+		{pkg: "example.com/a/b", bin: "example.com_a_b"},
+		{pkg: "example.com/100%/x", bin: "example.com_100%25_x"},
+		{pkg: "example.com/%5F", bin: "example.com_%255F"},
+	}
+	for _, tc := range testCases {
+		if bin := pkgToTestBin(tc.pkg); bin != tc.bin {
+			t.Errorf("%s: expected %q, found %q", tc.pkg, tc.bin, bin)
+		}
+		if pkg := testBinToPkg(tc.bin); pkg != tc.pkg {
+			t.Errorf("%s: expected %q, found %q", tc.bin, tc.pkg, pkg)
+		}
+	}
+	// Bazel test binaries of packages with several go_test targets.
+	if pkg := testBinToPkg("example.com_a%5Fb:b_test"); pkg != "example.com/a_b" {
+		t.Errorf("expected %q, found %q", "example.com/a_b", pkg)
+	}
+}
+
+func TestTestBinDir(t *testing.T) {
+	filter := []string{"./pkg/..."}
+	dir := testBinDir("abc", filter, ".", nil /* bazel */)
+	if other := testBinDir("abc", filter, "", nil /* bazel */); other != dir {
+		t.Errorf("expected patterns matching all benchmarks to share %s, found %s", dir, other)
+	}
+	if other := testBinDir("abc", filter, "Foo", nil /* bazel */); other == dir {
+		t.Errorf("expected a run pattern to get its own directory, found %s", other)
+	}
+	// Binaries built by Go and by Bazel, under different configs and startup
+	// flags, each get their own directory.
+	seen := map[string]string{dir: "go"}
+	for name, bazel := range map[string]*bazelOptions{
+		"bazel":          {},
+		"config":         {configs: []string{"opt"}},
+		"configs":        {configs: []string{"opt", "race"}},
+		"startup flags":  {startupFlags: []string{"--output_base=/tmp/b"}},
+		"config + flags": {configs: []string{"opt"}, startupFlags: []string{"--output_base=/tmp/b"}},
+	} {
+		d := testBinDir("abc", filter, ".", bazel)
+		if other, ok := seen[d]; ok {
+			t.Errorf("%s: expected its own directory, found that of %s", name, other)
+		}
+		seen[d] = name
+	}
+	// Directories of binaries named by an earlier scheme are not reused.
+	if old := filepath.Join(testDir("abc"), "bin", hash(filter)); old == dir {
+		t.Errorf("expected the naming scheme to be part of the key of %s", dir)
+	}
+}
+
+func TestPatternModule(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{root, filepath.Join(root, "sub", "mod")} {
+		if err := os.MkdirAll(filepath.Join(dir, "pkg"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module x\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(root)
+	// Resolve symlinks in the temporary directory's path, like os.Getwd.
+	root, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested := filepath.Join(root, "sub", "mod")
+	testCases := []struct {
+		pattern, dir, rel string
+	}{
+		{pattern: "./pkg/...", dir: "", rel: "./pkg/..."},
+		{pattern: "./...", dir: "", rel: "./..."},
+		{pattern: "example.com/pkg", dir: "", rel: "example.com/pkg"},
+		{pattern: "./sub/mod/pkg", dir: nested, rel: "./pkg"},
+		{pattern: "./sub/mod/...", dir: nested, rel: "./..."},
+		{pattern: filepath.Join(nested, "pkg"), dir: nested, rel: "./pkg"},
+	}
+	for _, tc := range testCases {
+		if tc.pattern == "./..." {
+			// The nested module is found by walking the directory tree.
+			exp := []string{"./sub/mod/..."}
+			if ps := nestedModulePatterns(tc.pattern, findModules(tc.pattern)); !reflect.DeepEqual(ps, exp) {
+				t.Errorf("%s: expected nested module patterns %q, found %q", tc.pattern, exp, ps)
+			}
+		}
+		dir, rel := patternModule(tc.pattern)
+		if dir != tc.dir || rel != tc.rel {
+			t.Errorf("%s: expected (%q, %q), found (%q, %q)", tc.pattern, tc.dir, tc.rel, dir, rel)
+		}
+	}
+}
+
+func TestBinManifest(t *testing.T) {
+	dir := t.TempDir()
+	if m, err := readBinManifest(dir); err != nil || m != nil {
+		t.Fatalf("expected no manifest, found %v, %v", m, err)
+	}
+	m := binManifest{
+		"example.com_a%5Fb":    {Pkg: "example.com/a_b", Ref: "abc"},
+		"example.com_c:c_test": {Pkg: "example.com/c", Ref: "abc", Target: "//c:c_test"},
+	}
+	if err := writeBinManifest(dir, m); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readBinManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, m) {
+		t.Errorf("expected %v, found %v", m, read)
+	}
+
+	bs := &benchSuite{bins: binManifest{"legacy_name": {Pkg: "example.com/legacy_name"}}}
+	if pkg := bs.testPkg("legacy_name"); pkg != "example.com/legacy_name" {
+		t.Errorf("expected the package from the manifest, found %q", pkg)
+	}
+	// Binaries without manifest entries fall back to their names.
+	if pkg := bs.testPkg("example.com_a%5Fb"); pkg != "example.com/a_b" {
+		t.Errorf("expected %q, found %q", "example.com/a_b", pkg)
+	}
+}
+
+func TestHasBenchmarks(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a_test.go": `package a
+
+import "testing"
+
+func BenchmarkFoo(b *testing.B) {}
+func Benchmarker(b *testing.B) {}
+func TestBar(t *testing.T) {}
+`,
+		"b_test.go": `package a_test
+
+import "testing"
+
+type s struct{}
+
+func (s) BenchmarkMethod(b *testing.B) {}
+func Benchmark_Under(b *testing.B) {}
+`,
+		"c_test.go": `package a
+
+func TestOnly() {}
+`,
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pkg := goPackage{Dir: dir, TestGoFiles: []string{"a_test.go"}, XTestGoFiles: []string{"b_test.go"}}
+	testCases := []struct {
+		pkg     goPackage
+		pattern string
+		exp     bool
+	}{
+		{pkg: pkg, pattern: ".", exp: true},
+		{pkg: pkg, pattern: "Foo", exp: true},
+		{pkg: pkg, pattern: "Foo/sub", exp: true},
+		{pkg: pkg, pattern: "Under", exp: true},
+		{pkg: pkg, pattern: "Benchmarker", exp: false},
+		{pkg: pkg, pattern: "Method", exp: false},
+		{pkg: pkg, pattern: "Bar", exp: false},
+		{pkg: goPackage{Dir: dir, TestGoFiles: []string{"c_test.go"}}, pattern: ".", exp: false},
+		{pkg: goPackage{Dir: dir}, pattern: ".", exp: false},
+	}
+	for _, tc := range testCases {
+		filtered, err := filterBenchPackages([]goPackage{tc.pkg}, tc.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found := len(filtered) == 1; found != tc.exp {
+			t.Errorf("%v %q: expected %t, found %t", tc.pkg.TestGoFiles, tc.pattern, tc.exp, found)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// timeBudget allocates the iterations of a benchmark run across packages so
+// that the run completes within a fixed amount of time. The cost of an
+// iteration of each package is estimated from the iterations run so far, and
+// the remaining time is spread across packages such that the package with the
+// fewest iterations, whose comparison has the least statistical power, gets
+// the next one.
+type timeBudget struct {
+	deadline time.Time
+	// maxIters is the maximum number of iterations of any package.
+	maxIters int
+	// profileIters is the number of profiled iterations of each package, which
+	// run after its timed iterations.
+	profileIters int
+	// costs and samples track the average duration of an iteration of each
+	// test binary, across both refs.
+	costs   map[string]time.Duration
+	samples map[string]int
+	// targets is the planned number of iterations of each test binary.
+	targets map[string]int
+}
+
+func newTimeBudget(d time.Duration, maxIters, profileIters int) *timeBudget {
+	return &timeBudget{
+		deadline:     time.Now().Add(d),
+		maxIters:     maxIters,
+		profileIters: profileIters,
+		costs:        make(map[string]time.Duration),
+		samples:      make(map[string]int),
+		targets:      make(map[string]int),
+	}
+}
+
+// observe records the duration of an iteration of the test binary.
+func (b *timeBudget) observe(test string, d time.Duration) {
+	n := b.samples[test]
+	b.costs[test] = (b.costs[test]*time.Duration(n) + d) / time.Duration(n+1)
+	b.samples[test] = n + 1
+}
+
+// estimated returns whether the cost of the test binary has been estimated.
+func (b *timeBudget) estimated(test string) bool {
+	return b.samples[test] > 0
+}
+
+// fits returns whether another iteration of the test binary is expected to
+// complete before the deadline.
+func (b *timeBudget) fits(test string) bool {
+	return !time.Now().Add(b.costs[test]).After(b.deadline)
+}
+
+// plan allocates the remaining time to the provided test binaries, which have
+// completed the provided number of timed and profiled iterations. The time of
+// the outstanding profiled iterations is set aside first.
+func (b *timeBudget) plan(tests []string, done, profiled map[string]int) {
+	remaining := time.Until(b.deadline) - b.profileCost(tests, profiled)
+	b.targets = allocateIterations(remaining, tests, b.costs, done, b.maxIters)
+}
+
+// profileCost returns the expected duration of the outstanding profiled
+// iterations of the test binaries. A profiled iteration is assumed to cost as
+// much as a timed one.
+func (b *timeBudget) profileCost(tests []string, profiled map[string]int) time.Duration {
+	var cost time.Duration
+	for _, t := range tests {
+		if n := b.profileIters - profiled[t]; n > 0 {
+			cost += time.Duration(n) * b.costs[t]
+		}
+	}
+	return cost
+}
+
+// eta returns the expected time until the planned iterations are complete.
+func (b *timeBudget) eta(tests []string, done, profiled map[string]int) time.Duration {
+	eta := b.profileCost(tests, profiled)
+	for _, t := range tests {
+		if n := b.targets[t] - done[t]; n > 0 {
+			eta += time.Duration(n) * b.costs[t]
+		}
+	}
+	return eta
+}
+
+// status describes the plan, like "remaining=47m12s eta=12m3s".
+func (b *timeBudget) status(tests []string, done, profiled map[string]int) string {
+	remaining := time.Until(b.deadline)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return fmt.Sprintf("remaining=%s eta=%s",
+		remaining.Round(time.Second), b.eta(tests, done, profiled).Round(time.Second))
+}
+
+// allocateIterations determines how many iterations of each test binary to
+// run in the remaining time, given the cost of an iteration of each and the
+// number of iterations already completed. Iterations are handed out one at a
+// time to the test binary with the fewest, preferring cheaper ones, until no
+// more fit or all have reached maxIters. This maximizes the smallest number of
+// iterations of any test binary.
+func allocateIterations(
+	remaining time.Duration,
+	tests []string,
+	costs map[string]time.Duration,
+	done map[string]int,
+	maxIters int,
+) map[string]int {
+	targets := make(map[string]int, len(tests))
+	for _, t := range tests {
+		targets[t] = done[t]
+	}
+	for {
+		next := ""
+		for _, t := range tests {
+			if targets[t] >= maxIters || costs[t] > remaining {
+				continue
+			}
+			if next == "" || targets[t] < targets[next] ||
+				(targets[t] == targets[next] && costs[t] < costs[next]) {
+				next = t
+			}
+		}
+		if next == "" {
+			return targets
+		}
+		targets[next]++
+		remaining -= costs[next]
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAllocateIterations(t *testing.T) {
+	tests := []string{"a", "b", "c"}
+	costs := map[string]time.Duration{"a": time.Second, "b": 2 * time.Second, "c": 4 * time.Second}
+	for _, tc := range []struct {
+		remaining time.Duration
+		done      map[string]int
+		maxIters  int
+		exp       map[string]int
+	}{
+		{
+			// Enough time for two iterations of each and another of a and b.
+			remaining: 17 * time.Second,
+			done:      map[string]int{},
+			maxIters:  10,
+			exp:       map[string]int{"a": 3, "b": 3, "c": 2},
+		},
+		{
+			// Packages with fewer completed iterations get more of the time.
+			remaining: 8 * time.Second,
+			done:      map[string]int{"a": 1, "b": 1, "c": 3},
+			maxIters:  10,
+			exp:       map[string]int{"a": 5, "b": 3, "c": 3},
+		},
+		{
+			// No package exceeds maxIters.
+			remaining: time.Hour,
+			done:      map[string]int{"a": 1},
+			maxIters:  5,
+			exp:       map[string]int{"a": 5, "b": 5, "c": 5},
+		},
+		{
+			// Packages that no longer fit in the remaining time are skipped.
+			remaining: 3 * time.Second,
+			done:      map[string]int{"a": 1, "b": 1, "c": 1},
+			maxIters:  10,
+			exp:       map[string]int{"a": 2, "b": 2, "c": 1},
+		},
+	} {
+		res := allocateIterations(tc.remaining, tests, costs, tc.done, tc.maxIters)
+		if !reflect.DeepEqual(res, tc.exp) {
+			t.Errorf("expected %v, found %v", tc.exp, res)
+		}
+	}
+}
+
+func TestTimeBudgetPlanReservesProfiles(t *testing.T) {
+	b := newTimeBudget(time.Hour, 10, 2 /* profileIters */)
+	b.deadline = time.Now().Add(10*time.Second + time.Second/2)
+	b.observe("a", time.Second)
+	// Two profiled iterations of a remain, so the timed ones get the rest of
+	// the time.
+	b.plan([]string{"a"}, map[string]int{"a": 1}, map[string]int{})
+	if exp := 9; b.targets["a"] != exp {
+		t.Errorf("expected %d iterations, found %d", exp, b.targets["a"])
+	}
+	b.plan([]string{"a"}, map[string]int{"a": 1}, map[string]int{"a": 2})
+	if exp := 10; b.targets["a"] != exp {
+		t.Errorf("expected %d iterations, found %d", exp, b.targets["a"])
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// runCheckpoint records the progress of a benchmark run so that the run can be
+// resumed if it is interrupted. It is written alongside the run's output files
+// in the artifacts directory of both the old and the new ref after every
+// completed iteration.
+type runCheckpoint struct {
+	// Completed maps each test binary to the number of iterations of it that
+	// have been completed for both refs.
+	Completed map[string]int `json:"completed"`
+	// Profiled maps each test binary to the number of profiled iterations of
+	// it that have been completed for both refs. The profiles of an iteration
+	// are merged only after it is recorded here, so a resumed run does not
+	// merge them again.
+	Profiled map[string]int `json:"profiled,omitempty"`
+	// Offsets maps each ref to the size of its output file as of the last
+	// completed iteration. When resuming, any output past this offset belongs
+	// to an incomplete iteration and is discarded.
+	Offsets map[string]int64 `json:"offsets"`
+}
+
+func makeRunCheckpoint() runCheckpoint {
+	return runCheckpoint{
+		Completed: make(map[string]int),
+		Profiled:  make(map[string]int),
+		Offsets:   make(map[string]int64),
+	}
+}
+
+// readCheckpoint reads the checkpoint of the run that started at the provided
+// time from the benchmark suite's artifacts directory. If the run has not
+// completed any iterations, an empty checkpoint is returned.
+func readCheckpoint(bs *benchSuite, t time.Time) (runCheckpoint, error) {
+	c := makeRunCheckpoint()
+	b, err := os.ReadFile(bs.getCheckpointFile(t))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return c, err
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, errors.Wrap(err, "reading run checkpoint")
+	}
+	if c.Profiled == nil {
+		c.Profiled = make(map[string]int)
+	}
+	return c, nil
+}
+
+// save marks another iteration of the test binary as complete and writes the
+// checkpoint to the artifacts directory of each of the benchmark suites.
+func (c *runCheckpoint) save(t time.Time, test string, bss ...*benchSuite) error {
+	c.Completed[test]++
+	for _, bs := range bss {
+		fi, err := bs.outFile.Stat()
+		if err != nil {
+			return err
+		}
+		c.Offsets[bs.ref] = fi.Size()
+	}
+	return c.write(t, bss...)
+}
+
+// saveProfiled marks another profiled iteration of the test binary as
+// complete and writes the checkpoint. Profiled iterations do not write to the
+// output files.
+func (c *runCheckpoint) saveProfiled(t time.Time, test string, bss ...*benchSuite) error {
+	c.Profiled[test]++
+	return c.write(t, bss...)
+}
+
+func (c *runCheckpoint) write(t time.Time, bss ...*benchSuite) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	for _, bs := range bss {
+		// Write the checkpoint atomically so that a crash while writing it
+		// does not corrupt the previous checkpoint.
+		path := bs.getCheckpointFile(t)
+		if err := os.WriteFile(path+".tmp", b, 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restore discards any output of incomplete iterations from the output files
+// of each of the benchmark suites and positions the files so that subsequent
+// output is appended to them.
+func (c *runCheckpoint) restore(bss ...*benchSuite) error {
+	for _, bs := range bss {
+		off := c.Offsets[bs.ref]
+		if err := bs.outFile.Truncate(off); err != nil {
+			return errors.Wrap(err, "truncating output file")
+		}
+		if _, err := bs.outFile.Seek(off, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return nil
+}
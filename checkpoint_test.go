@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRunCheckpointSaveRestore(t *testing.T) {
+	dir := t.TempDir()
+	runTime := time.Date(2021, 7, 20, 18, 47, 32, 0, time.UTC)
+	var suites []*benchSuite
+	for _, ref := range []string{"old", "new"} {
+		bs := &benchSuite{ref: ref, artDir: filepath.Join(dir, ref)}
+		if err := os.Mkdir(bs.artDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		if bs.outFile, err = os.Create(bs.getOutputFile(runTime)); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(bs.close)
+		suites = append(suites, bs)
+	}
+	bs1, bs2 := suites[0], suites[1]
+
+	// A run without a checkpoint has not completed any iterations.
+	c, err := readCheckpoint(bs1, runTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Completed) != 0 || len(c.Profiled) != 0 {
+		t.Fatalf("expected an empty checkpoint, found %+v", c)
+	}
+
+	write := func(bs *benchSuite, s string) {
+		t.Helper()
+		if _, err := io.WriteString(bs.outFile, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(bs1, "old iteration 1\n")
+	write(bs2, "new iteration 1\n")
+	if err := c.save(runTime, "pkg", bs1, bs2); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.saveProfiled(runTime, "pkg", bs1, bs2); err != nil {
+		t.Fatal(err)
+	}
+	// The second iteration is interrupted after the old ref's output was
+	// written.
+	write(bs1, "old iteration 2\n")
+
+	for _, bs := range suites {
+		read, err := readCheckpoint(bs, runTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(read, c) {
+			t.Errorf("%s: expected %+v, found %+v", bs.ref, c, read)
+		}
+	}
+	if err := c.restore(bs1, bs2); err != nil {
+		t.Fatal(err)
+	}
+	// The output of the interrupted iteration is discarded, and subsequent
+	// output is appended to that of the completed iteration.
+	write(bs1, "old iteration 2 again\n")
+	for bs, exp := range map[*benchSuite]string{
+		bs1: "old iteration 1\nold iteration 2 again\n",
+		bs2: "new iteration 1\n",
+	} {
+		b, err := os.ReadFile(bs.outFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != exp {
+			t.Errorf("%s: expected output %q, found %q", bs.ref, exp, b)
+		}
+	}
+}
+
+func TestResumeProfiledIteration(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	runTime := time.Date(2021, 7, 20, 18, 47, 32, 0, time.UTC)
+	ckpt := makeRunCheckpoint()
+	r := newFakeBenchRunner(t, dir, runTime, &ckpt)
+	progress := func(string) {}
+
+	if err := r.runProfileIteration(ctx, "pkg", nil /* cpus */, progress); err != nil {
+		t.Fatal(err)
+	}
+	// The second iteration is interrupted after the old ref's invocation.
+	setFakeMode(t, r.bs2, "crash")
+	if err := r.runProfileIteration(ctx, "pkg", nil /* cpus */, progress); err == nil {
+		t.Fatal("expected the crash to abort the iteration")
+	}
+	r.bs1.close()
+	r.bs2.close()
+
+	// Resume the run from its checkpoint.
+	resumed, err := readCheckpoint(r.bs2, runTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := resumed.Profiled["pkg"]; n != 1 {
+		t.Fatalf("expected 1 checkpointed profiled iteration, found %d", n)
+	}
+	r = newFakeBenchRunner(t, dir, runTime, &resumed)
+	if err := resumed.restore(r.bs1, r.bs2); err != nil {
+		t.Fatal(err)
+	}
+	setFakeMode(t, r.bs2, "")
+	if err := r.runProfileIteration(ctx, "pkg", nil /* cpus */, progress); err != nil {
+		t.Fatal(err)
+	}
+	// The samples of each iteration are merged exactly once, even though
+	// the old ref ran the interrupted iteration twice.
+	for _, bs := range []*benchSuite{r.bs1, r.bs2} {
+		if total := profileTotal(t, bs.getProfileFile(runTime, "cpu")); total != 2*fakeProfileTotal {
+			t.Errorf("%s: expected the samples of 2 iterations (%g), found %g", bs.ref, 2*fakeProfileTotal, total)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/pprof/profile"
+	"github.com/pkg/errors"
+	"golang.org/x/perf/benchstat"
+)
+
+// culpritsTopN is the number of functions listed as likely culprits of
+// regressions.
+const culpritsTopN = 5
+
+// culpritMetrics maps the metrics whose regressions are attributed to
+// functions to the profile type and sample type that they are attributed with.
+var culpritMetrics = []struct {
+	metric, profType, sampleType string
+}{
+	{metric: "time/op", profType: "cpu", sampleType: "cpu"},
+	{metric: "alloc/op", profType: "mem", sampleType: "alloc_space"},
+}
+
+// culprit is a function whose share of a profile's samples grew.
+type culprit struct {
+	function           string
+	oldShare, newShare float64 // fractions of the samples of each profile
+}
+
+// culpritGroup lists the likely culprits of the regressions in a metric of
+// the benchmarks that share a profile.
+type culpritGroup struct {
+	metric     string
+	profile    profilePair
+	benchmarks []string // with their deltas, like "Foo-8 (+12.34%)"
+	culprits   []culprit
+}
+
+// procsSuffix matches the GOMAXPROCS suffix of benchmark names.
+var procsSuffix = regexp.MustCompile(`-\d+$`)
+
+// topLevelBenchmark returns the name of the top-level benchmark of a row, like
+// "Foo" for "Foo/size=64-8", which is the benchmark that was profiled.
+func topLevelBenchmark(name string) string {
+	name = procsSuffix.ReplaceAllString(name, "")
+	if i := strings.Index(name, "/"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// attributeRegressions finds the likely culprits of the significant
+// regressions in the tables. A regression is attributed with the profile of
+// its benchmark, if it was profiled on its own with --profile-run, or else
+// with the profile of all profiled benchmarks.
+func attributeRegressions(tables []*benchstat.Table, pairs []profilePair) ([]culpritGroup, error) {
+	var groups []culpritGroup
+	for _, cm := range culpritMetrics {
+		var agg *profilePair
+		benches := make(map[string][]profilePair)
+		for i, p := range pairs {
+			switch {
+			case p.profType != cm.profType:
+			case p.bench == "":
+				agg = &pairs[i]
+			default:
+				name := strings.TrimPrefix(p.bench, "Benchmark")
+				benches[name] = append(benches[name], p)
+			}
+		}
+
+		var mGroups []culpritGroup
+		for _, table := range tables {
+			if table.Metric != cm.metric {
+				continue
+			}
+			for _, row := range table.Rows {
+				if row.Change != -1 {
+					continue
+				}
+				p := agg
+				// Benchmarks of the same name in different packages share a
+				// row, so their profiles are ambiguous.
+				if ps := benches[topLevelBenchmark(row.Benchmark)]; len(ps) == 1 {
+					p = &ps[0]
+				}
+				if p == nil {
+					continue
+				}
+				bench := fmt.Sprintf("%s (%s)", row.Benchmark, row.Delta)
+				found := false
+				for i := range mGroups {
+					if mGroups[i].profile == *p {
+						mGroups[i].benchmarks = append(mGroups[i].benchmarks, bench)
+						found = true
+						break
+					}
+				}
+				if !found {
+					mGroups = append(mGroups, culpritGroup{metric: cm.metric, profile: *p, benchmarks: []string{bench}})
+				}
+			}
+		}
+		for i := range mGroups {
+			g := &mGroups[i]
+			var err error
+			g.culprits, err = findCulprits(g.profile.old, g.profile.new, cm.sampleType, culpritsTopN)
+			if err != nil {
+				return nil, errors.Wrapf(err, "attributing %s regressions", cm.metric)
+			}
+		}
+		groups = append(groups, mGroups...)
+	}
+	return groups, nil
+}
+
+// findCulprits returns the n functions whose share of the flat samples of the
+// provided sample type grew the most between the old and the new profile.
+func findCulprits(oldPath, newPath, sampleType string, n int) ([]culprit, error) {
+	oldShares, err := readFlatShares(oldPath, sampleType)
+	if err != nil {
+		return nil, err
+	}
+	newShares, err := readFlatShares(newPath, sampleType)
+	if err != nil {
+		return nil, err
+	}
+	var cs []culprit
+	for fn, s := range newShares {
+		if s > oldShares[fn] {
+			cs = append(cs, culprit{function: fn, oldShare: oldShares[fn], newShare: s})
+		}
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		di, dj := cs[i].newShare-cs[i].oldShare, cs[j].newShare-cs[j].oldShare
+		if di != dj {
+			return di > dj
+		}
+		return cs[i].function < cs[j].function
+	})
+	if len(cs) > n {
+		cs = cs[:n]
+	}
+	return cs, nil
+}
+
+// readFlatShares reads the profile at the provided path and returns the share
+// of the values of the provided sample type that each function accounts for
+// itself, excluding its callees.
+func readFlatShares(path, sampleType string) (map[string]float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p, err := profile.Parse(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", path)
+	}
+	return flatShares(p, sampleType)
+}
+
+func flatShares(p *profile.Profile, sampleType string) (map[string]float64, error) {
+	idx := -1
+	for i, st := range p.SampleType {
+		if st.Type == sampleType {
+			idx = i
+		}
+	}
+	if idx == -1 {
+		return nil, errors.Errorf("profile has no %s samples", sampleType)
+	}
+	flat := make(map[string]int64)
+	var total int64
+	for _, s := range p.Sample {
+		v := s.Value[idx]
+		total += v
+		// The first line of the leaf location is the innermost function,
+		// which may have been inlined into the others.
+		if len(s.Location) == 0 || len(s.Location[0].Line) == 0 {
+			continue
+		}
+		if fn := s.Location[0].Line[0].Function; fn != nil {
+			flat[fn.Name] += v
+		}
+	}
+	shares := make(map[string]float64, len(flat))
+	if total == 0 {
+		return shares, nil
+	}
+	for fn, v := range flat {
+		shares[fn] = float64(v) / float64(total)
+	}
+	return shares, nil
+}
+
+// formatCulpritsText writes the likely culprits of each group of regressions.
+func formatCulpritsText(w io.Writer, groups []culpritGroup) {
+	for _, g := range groups {
+		if len(g.culprits) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\nlikely culprits of %s regressions in %s, by growth in %s profile share:\n",
+			g.metric, strings.Join(g.benchmarks, ", "), g.profile.name())
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  delta\told\tnew\tfunction")
+		for _, c := range g.culprits {
+			fmt.Fprintf(tw, "  %+.1f%%\t%.1f%%\t%.1f%%\t%s\n",
+				(c.newShare-c.oldShare)*100, c.oldShare*100, c.newShare*100, c.function)
+		}
+		_ = tw.Flush()
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"golang.org/x/perf/benchstat"
+)
+
+// writeCPUProfile writes a CPU profile with a sample of the provided value in
+// each of the functions.
+func writeCPUProfile(t *testing.T, path string, values map[string]int64) {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+	}
+	var id uint64
+	for name, v := range values {
+		id++
+		fn := &profile.Function{ID: id, Name: name}
+		loc := &profile.Location{ID: id, Line: []profile.Line{{Function: fn}}}
+		p.Function = append(p.Function, fn)
+		p.Location = append(p.Location, loc)
+		p.Sample = append(p.Sample, &profile.Sample{Location: []*profile.Location{loc}, Value: []int64{1, v}})
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := p.Write(f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAttributeRegressions(t *testing.T) {
+	dir := t.TempDir()
+	agg := profilePair{profType: "cpu", old: filepath.Join(dir, "old.prof"), new: filepath.Join(dir, "new.prof")}
+	writeCPUProfile(t, agg.old, map[string]int64{"a": 50, "b": 30, "c": 20})
+	writeCPUProfile(t, agg.new, map[string]int64{"a": 40, "b": 30, "c": 60, "d": 70})
+	foo := profilePair{profType: "cpu", test: "pkg", bench: "BenchmarkFoo",
+		old: filepath.Join(dir, "foo", "old.prof"), new: filepath.Join(dir, "foo", "new.prof")}
+	writeCPUProfile(t, foo.old, map[string]int64{"a": 100})
+	writeCPUProfile(t, foo.new, map[string]int64{"a": 100, "e": 100})
+
+	tables := []*benchstat.Table{{
+		Metric: "time/op",
+		Rows: []*benchstat.Row{
+			{Benchmark: "Foo-8", Delta: "+10.00%", Change: -1},
+			{Benchmark: "Foo/size=64-8", Delta: "+8.00%", Change: -1},
+			{Benchmark: "Bar-8", Delta: "+5.00%", Change: -1},
+			{Benchmark: "Baz-8", Delta: "-5.00%", Change: 1},
+			{Benchmark: "Qux-8", Delta: "~", Change: 0},
+			{Benchmark: "Quux-8", Delta: "+3.00%", Change: -1},
+		},
+	}, {
+		// No memory profiles were recorded.
+		Metric: "alloc/op",
+		Rows:   []*benchstat.Row{{Benchmark: "Bar-8", Delta: "+5.00%", Change: -1}},
+	}}
+	groups, err := attributeRegressions(tables, []profilePair{agg, foo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []culpritGroup{{
+		metric:     "time/op",
+		profile:    foo,
+		benchmarks: []string{"Foo-8 (+10.00%)", "Foo/size=64-8 (+8.00%)"},
+		culprits:   []culprit{{function: "e", oldShare: 0, newShare: 0.5}},
+	}, {
+		metric:     "time/op",
+		profile:    agg,
+		benchmarks: []string{"Bar-8 (+5.00%)", "Quux-8 (+3.00%)"},
+		culprits: []culprit{
+			{function: "d", oldShare: 0, newShare: 0.35},
+			{function: "c", oldShare: 0.2, newShare: 0.3},
+		},
+	}}
+	if !reflect.DeepEqual(groups, exp) {
+		t.Errorf("expected %+v, found %+v", exp, groups)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/perf/benchstat"
+)
+
+// resultsDBFile is the name of the local results database, which lives in the
+// top-level benchdiff directory alongside the per-ref directories.
+const resultsDBFile = "results.db"
+
+var runsBucket = []byte("runs")
+
+// resultsDB is a local, embedded database that records every benchmark run.
+// Runs are keyed by the time at which they were started, so reprocessing a
+// previous run overwrites its record instead of duplicating it.
+type resultsDB struct {
+	db *bolt.DB
+}
+
+// runRecord is the persisted form of a single benchmark run.
+type runRecord struct {
+	Time      time.Time     `json:"time"`
+	Old       refRecord     `json:"old"`
+	New       refRecord     `json:"new"`
+	Args      []string      `json:"args"`
+	PkgFilter []string      `json:"pkg_filter"`
+	Config    configRecord  `json:"config"`
+	Host      *hostInfo     `json:"host,omitempty"` // nil if unknown
+	Tables    []tableRecord `json:"tables"`
+}
+
+// refRecord describes one side of a benchmark comparison, including the raw
+// benchmark output that was gathered for it.
+type refRecord struct {
+	Ref     string `json:"ref"`
+	Subject string `json:"subject"`
+	Samples string `json:"samples"`
+}
+
+// configRecord describes how the benchmarks were built and run.
+type configRecord struct {
+	Bazel      bool   `json:"bazel"`
+	RunPattern string `json:"run_pattern"`
+	BenchTime  string `json:"bench_time"`
+	Count      int    `json:"count"`
+}
+
+// tableRecord is the persisted form of a benchstat.Table. benchstat.Table
+// cannot be encoded directly because each row holds a Scaler function.
+type tableRecord struct {
+	Metric string      `json:"metric"`
+	Rows   []rowRecord `json:"rows"`
+}
+
+type rowRecord struct {
+	Benchmark string          `json:"benchmark"`
+	Group     string          `json:"group,omitempty"`
+	Old       *metricsRecord  `json:"old,omitempty"`
+	New       *metricsRecord  `json:"new,omitempty"`
+	PctDelta  float64         `json:"pct_delta"`
+	Delta     string          `json:"delta"`
+	Note      string          `json:"note"`
+	Change    int             `json:"change"`
+	Extra     []metricsRecord `json:"extra,omitempty"`
+}
+
+type metricsRecord struct {
+	Unit   string    `json:"unit"`
+	Values []float64 `json:"values"`
+	Min    float64   `json:"min"`
+	Mean   float64   `json:"mean"`
+	Max    float64   `json:"max"`
+}
+
+// openResultsDB opens the results database in the provided directory,
+// creating it if it does not exist.
+func openResultsDB(dir string) (*resultsDB, error) {
+	if err := os.MkdirAll(dir, 0744); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, resultsDBFile)
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening results database %s", path)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(runsBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &resultsDB{db: db}, nil
+}
+
+func (r *resultsDB) close() error {
+	return r.db.Close()
+}
+
+func runKey(t time.Time) []byte {
+	var k [8]byte
+	binary.BigEndian.PutUint64(k[:], uint64(t.UnixNano()))
+	return k[:]
+}
+
+// putRun records the run, replacing any existing record of a run that started
+// at the same time.
+func (r *resultsDB) putRun(rec runRecord) error {
+	v, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(runsBucket).Put(runKey(rec.Time), v)
+	})
+}
+
+// forEachRun calls fn on every recorded run, from oldest to newest.
+func (r *resultsDB) forEachRun(fn func(runRecord) error) error {
+	return r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(runsBucket).ForEach(func(_, v []byte) error {
+			var rec runRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			return fn(rec)
+		})
+	})
+}
+
+// makeRunRecord constructs the record of a run from its metadata, its raw
+// benchmark output, and the comparison tables computed from that output.
+func makeRunRecord(md runMetadata, oldSamples, newSamples string, tables []*benchstat.Table) runRecord {
+	rec := runRecord{
+		Time:      md.Time,
+		Old:       refRecord{Ref: md.OldRef, Subject: md.OldSubject, Samples: oldSamples},
+		New:       refRecord{Ref: md.NewRef, Subject: md.NewSubject, Samples: newSamples},
+		Args:      md.Args,
+		PkgFilter: md.PkgFilter,
+		Config: configRecord{
+			Bazel:      md.Bazel,
+			RunPattern: md.RunPattern,
+			BenchTime:  md.BenchTime,
+			Count:      md.Count,
+		},
+		Host: md.Host,
+	}
+	for _, t := range tables {
+		tr := tableRecord{Metric: t.Metric}
+		for _, row := range t.Rows {
+			rr := rowRecord{
+				Benchmark: row.Benchmark,
+				Group:     row.Group,
+				PctDelta:  row.PctDelta,
+				Delta:     row.Delta,
+				Note:      row.Note,
+				Change:    row.Change,
+			}
+			for i, m := range row.Metrics {
+				mr := makeMetricsRecord(m)
+				switch {
+				case i == 0 && t.OldNewDelta:
+					rr.Old = &mr
+				case i == 1 && t.OldNewDelta:
+					rr.New = &mr
+				default:
+					rr.Extra = append(rr.Extra, mr)
+				}
+			}
+			tr.Rows = append(tr.Rows, rr)
+		}
+		rec.Tables = append(rec.Tables, tr)
+	}
+	return rec
+}
+
+func makeMetricsRecord(m *benchstat.Metrics) metricsRecord {
+	return metricsRecord{
+		Unit:   m.Unit,
+		Values: m.Values,
+		Min:    m.Min,
+		Mean:   m.Mean,
+		Max:    m.Max,
+	}
+}
+
+// recordRun stores the run in the results database in the top-level benchdiff
+// directory.
+func recordRun(md runMetadata, oldSuite, newSuite *benchSuite, tables []*benchstat.Table) error {
+	oldSamples, err := os.ReadFile(oldSuite.outFile.Name())
+	if err != nil {
+		return err
+	}
+	newSamples, err := os.ReadFile(newSuite.outFile.Name())
+	if err != nil {
+		return err
+	}
+	db, err := openResultsDB(benchdiffDir)
+	if err != nil {
+		return err
+	}
+	defer db.close()
+	return db.putRun(makeRunRecord(md, string(oldSamples), string(newSamples), tables))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"golang.org/x/perf/benchstat"
+)
+
+func TestResultsDBHistory(t *testing.T) {
+	db, err := openResultsDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.close()
+
+	mkTables := func(oldMean, newMean float64) []*benchstat.Table {
+		return []*benchstat.Table{{
+			Metric:      "time/op",
+			OldNewDelta: true,
+			Configs:     []string{"old", "new"},
+			Rows: []*benchstat.Row{{
+				Benchmark: "String-8",
+				Metrics: []*benchstat.Metrics{
+					{Unit: "ns/op", Mean: oldMean, Values: []float64{oldMean}},
+					{Unit: "ns/op", Mean: newMean, Values: []float64{newMean}},
+				},
+				Delta: "~",
+			}},
+		}}
+	}
+	t1 := time.Date(2021, 7, 20, 18, 47, 32, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	runs := []runRecord{
+		makeRunRecord(runMetadata{Time: t1, OldRef: "aaaaaaa", NewRef: "bbbbbbb"}, "", "", mkTables(10, 12)),
+		makeRunRecord(runMetadata{Time: t2, OldRef: "bbbbbbb", NewRef: "ccccccc"}, "", "", mkTables(11, 9)),
+	}
+	for _, rec := range runs {
+		if err := db.putRun(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Re-recording a run replaces it.
+	if err := db.putRun(runs[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err := db.forEachRun(func(runRecord) error { n++; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 runs, found %d", n)
+	}
+
+	hist, err := collectHistory(db, regexp.MustCompile("String"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := hist[historyKey{benchmark: "String-8", metric: "time/op"}]
+	type exp struct {
+		ref  string
+		mean float64
+	}
+	// Commit bbbbbbb was measured twice. Its latest measurement is used.
+	expEntries := []exp{{"aaaaaaa", 10}, {"bbbbbbb", 11}, {"ccccccc", 9}}
+	if len(entries) != len(expEntries) {
+		t.Fatalf("expected %d entries, found %d", len(expEntries), len(entries))
+	}
+	for i, e := range expEntries {
+		if entries[i].ref != e.ref || entries[i].metrics.Mean != e.mean {
+			t.Errorf("entry %d: expected %s=%f, found %s=%f",
+				i, e.ref, e.mean, entries[i].ref, entries[i].metrics.Mean)
+		}
+	}
+
+	hist, err = collectHistory(db, regexp.MustCompile("String"), "alloc/op")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hist) != 0 {
+		t.Fatalf("expected no alloc/op history, found %v", hist)
+	}
+
+	// Same-named benchmarks in different packages form separate series.
+	grouped := mkTables(20, 21)
+	row := *grouped[0].Rows[0]
+	grouped[0].Rows[0].Group = "pkg:example.com/a"
+	row.Group = "pkg:example.com/b"
+	grouped[0].Rows = append(grouped[0].Rows, &row)
+	t3 := t2.Add(time.Hour)
+	if err := db.putRun(makeRunRecord(runMetadata{Time: t3, OldRef: "ccccccc", NewRef: "ddddddd"}, "", "", grouped)); err != nil {
+		t.Fatal(err)
+	}
+	hist, err = collectHistory(db, regexp.MustCompile("String"), "time/op")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, group := range []string{"", "pkg:example.com/a", "pkg:example.com/b"} {
+		key := historyKey{group: group, benchmark: "String-8", metric: "time/op"}
+		if _, ok := hist[key]; !ok {
+			t.Errorf("expected a series for %v", key)
+		}
+	}
+	if n := len(hist[historyKey{group: "pkg:example.com/a", benchmark: "String-8", metric: "time/op"}]); n != 2 {
+		t.Errorf("expected 2 entries in the series of package a, found %d", n)
+	}
+}
+
+func TestMakeRunRecordHost(t *testing.T) {
+	db, err := openResultsDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.close()
+
+	host := &hostInfo{
+		Hostname:  "bench-1",
+		GoVersion: "go1.21.4 linux/arm64",
+		GOARCH:    "arm64",
+		CPUModel:  "Neoverse-N1",
+		Cores:     64,
+		Memory:    256 << 30,
+		Kernel:    "Linux 6.1.0",
+		BenchConfig: map[string][]string{
+			"aaaaaaa": {"goos: linux", "goarch: arm64"},
+		},
+	}
+	t1 := time.Date(2021, 7, 20, 18, 47, 32, 0, time.UTC)
+	// The host is that of the run, not of the machine that records it. Runs
+	// whose host is unknown are recorded without one.
+	for _, md := range []runMetadata{
+		{Time: t1, Host: host},
+		{Time: t1.Add(time.Hour)},
+	} {
+		if err := db.putRun(makeRunRecord(md, "", "", nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var hosts []*hostInfo
+	if err := db.forEachRun(func(rec runRecord) error {
+		hosts = append(hosts, rec.Host)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if exp := []*hostInfo{host, nil}; !reflect.DeepEqual(hosts, exp) {
+		t.Errorf("expected %+v, found %+v", exp, hosts)
+	}
+}
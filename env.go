@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// envSampleWindow is the window over which the CPU usage of processes is
+	// measured when sampling the environment.
+	envSampleWindow = time.Second
+	// envMonitorInterval is how often the environment is sampled while
+	// benchmarks are running.
+	envMonitorInterval = 30 * time.Second
+	// maxQuietLoadAvg is the highest 1-minute load average of a quiet machine.
+	maxQuietLoadAvg = 1.0
+	// maxQuietProcCPU is the highest CPU usage, in percent of a CPU, of any
+	// other process on a quiet machine.
+	maxQuietProcCPU = 10.0
+	// clockTicks is the unit of the CPU times in /proc/<pid>/stat. It is
+	// almost always 100 on Linux.
+	clockTicks = 100
+)
+
+// envSample is a snapshot of the conditions on the machine that affect the
+// reliability of benchmark results. It is gathered from /proc and /sys, so it
+// is empty on platforms other than Linux.
+type envSample struct {
+	Time time.Time `json:"time"`
+	// LoadAvg is the 1-minute load average.
+	LoadAvg float64 `json:"load_avg"`
+	// Governors are the distinct CPU frequency scaling governors in use.
+	Governors []string `json:"governors,omitempty"`
+	// Turbo is "on" or "off", or empty if turbo boost state is unknown.
+	Turbo string `json:"turbo,omitempty"`
+	// Throttles is the total number of thermal throttling events of all CPUs
+	// since boot, or -1 if unknown.
+	Throttles int64 `json:"throttles"`
+	// BusyProcs are the other processes that were busy while sampling.
+	BusyProcs []busyProc `json:"busy_procs,omitempty"`
+}
+
+// busyProc is a process that is using a significant amount of CPU.
+type busyProc struct {
+	PID  int     `json:"pid"`
+	Name string  `json:"name"`
+	CPU  float64 `json:"cpu"` // percent of a CPU
+}
+
+// envReport describes the conditions observed over the course of a run.
+type envReport struct {
+	Samples  []envSample `json:"samples"`
+	Warnings []string    `json:"warnings,omitempty"`
+}
+
+// summary describes the observed conditions in a line, like "load 0.12-0.98,
+// governor performance, turbo off, 0 throttling events".
+func (r *envReport) summary() string {
+	if len(r.Samples) == 0 {
+		return ""
+	}
+	minLoad, maxLoad := r.Samples[0].LoadAvg, r.Samples[0].LoadAvg
+	for _, s := range r.Samples {
+		if s.LoadAvg < minLoad {
+			minLoad = s.LoadAvg
+		}
+		if s.LoadAvg > maxLoad {
+			maxLoad = s.LoadAvg
+		}
+	}
+	first, last := r.Samples[0], r.Samples[len(r.Samples)-1]
+	parts := []string{fmt.Sprintf("load %.2f-%.2f", minLoad, maxLoad)}
+	if len(last.Governors) > 0 {
+		parts = append(parts, "governor "+strings.Join(last.Governors, "/"))
+	}
+	if last.Turbo != "" {
+		parts = append(parts, "turbo "+last.Turbo)
+	}
+	if first.Throttles >= 0 && last.Throttles >= 0 {
+		parts = append(parts, fmt.Sprintf("%d throttling events", last.Throttles-first.Throttles))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// sampleEnv samples the environment. It takes envSampleWindow to measure the
+// CPU usage of processes.
+func sampleEnv() envSample {
+	s := envSample{Time: time.Now(), Throttles: -1}
+	if b, err := os.ReadFile("/proc/loadavg"); err == nil {
+		if f := strings.Fields(string(b)); len(f) > 0 {
+			s.LoadAvg, _ = strconv.ParseFloat(f[0], 64)
+		}
+	}
+
+	govs := make(map[string]struct{})
+	paths, _ := filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/cpufreq/scaling_governor")
+	for _, p := range paths {
+		if g := readSysFile(p); g != "" {
+			govs[g] = struct{}{}
+		}
+	}
+	for g := range govs {
+		s.Governors = append(s.Governors, g)
+	}
+	sort.Strings(s.Governors)
+
+	// intel_pstate exposes whether turbo is disabled, acpi-cpufreq whether
+	// boost is enabled.
+	switch {
+	case readSysFile("/sys/devices/system/cpu/intel_pstate/no_turbo") == "1":
+		s.Turbo = "off"
+	case readSysFile("/sys/devices/system/cpu/intel_pstate/no_turbo") == "0":
+		s.Turbo = "on"
+	case readSysFile("/sys/devices/system/cpu/cpufreq/boost") == "1":
+		s.Turbo = "on"
+	case readSysFile("/sys/devices/system/cpu/cpufreq/boost") == "0":
+		s.Turbo = "off"
+	}
+
+	paths, _ = filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/thermal_throttle/core_throttle_count")
+	for i, p := range paths {
+		n, err := strconv.ParseInt(readSysFile(p), 10, 64)
+		if err != nil {
+			continue
+		}
+		if i == 0 {
+			s.Throttles = 0
+		}
+		s.Throttles += n
+	}
+
+	s.BusyProcs = findBusyProcs(envSampleWindow)
+	return s
+}
+
+func readSysFile(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// procStat is the subset of /proc/<pid>/stat that is used to find busy
+// processes.
+type procStat struct {
+	name  string
+	ppid  int
+	ticks int64 // user and system CPU time
+}
+
+func readProcStats() map[int]procStat {
+	stats := make(map[int]procStat)
+	dirs, _ := filepath.Glob("/proc/[0-9]*")
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(filepath.Base(d))
+		if err != nil {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(d, "stat"))
+		if err != nil {
+			continue
+		}
+		st, ok := parseProcStat(string(b))
+		if !ok {
+			continue
+		}
+		stats[pid] = st
+	}
+	return stats
+}
+
+// parseProcStat parses the contents of /proc/<pid>/stat.
+func parseProcStat(line string) (procStat, bool) {
+	// The process name is parenthesized and may itself contain spaces and
+	// parentheses, so parse the fields after the last parenthesis.
+	open, close := strings.IndexByte(line, '('), strings.LastIndexByte(line, ')')
+	if open < 0 || close < open {
+		return procStat{}, false
+	}
+	f := strings.Fields(line[close+1:])
+	// f[0] is the state, f[1] the ppid, f[11] utime and f[12] stime.
+	if len(f) < 13 {
+		return procStat{}, false
+	}
+	ppid, err1 := strconv.Atoi(f[1])
+	utime, err2 := strconv.ParseInt(f[11], 10, 64)
+	stime, err3 := strconv.ParseInt(f[12], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return procStat{}, false
+	}
+	return procStat{name: line[open+1 : close], ppid: ppid, ticks: utime + stime}, true
+}
+
+// findBusyProcs finds the processes other than benchdiff and its descendants,
+// like the test binaries, that use more than maxQuietProcCPU over the window.
+func findBusyProcs(window time.Duration) []busyProc {
+	before := readProcStats()
+	if len(before) == 0 {
+		return nil
+	}
+	time.Sleep(window)
+	after := readProcStats()
+
+	self := os.Getpid()
+	isOurs := func(pid int) bool {
+		for i := 0; pid > 1 && i < 64; i++ {
+			if pid == self {
+				return true
+			}
+			pid = after[pid].ppid
+		}
+		return false
+	}
+	var busy []busyProc
+	for pid, a := range after {
+		b, ok := before[pid]
+		if !ok || isOurs(pid) {
+			continue
+		}
+		cpu := float64(a.ticks-b.ticks) / clockTicks / window.Seconds() * 100
+		if cpu > maxQuietProcCPU {
+			busy = append(busy, busyProc{PID: pid, Name: a.name, CPU: cpu})
+		}
+	}
+	sort.Slice(busy, func(i, j int) bool { return busy[i].CPU > busy[j].CPU })
+	return busy
+}
+
+// warnings returns the conditions in the sample that make the machine noisy.
+// The load average is only checked before the benchmarks start, as the
+// benchmarks themselves contribute to it.
+func (s envSample) warnings(checkLoad bool) []string {
+	var ws []string
+	if checkLoad && s.LoadAvg > maxQuietLoadAvg {
+		ws = append(ws, fmt.Sprintf("load average is %.2f", s.LoadAvg))
+	}
+	for _, g := range s.Governors {
+		if g != "performance" {
+			ws = append(ws, fmt.Sprintf("CPU frequency governor is %q, not \"performance\"", g))
+		}
+	}
+	if s.Turbo == "on" {
+		ws = append(ws, "turbo boost is enabled")
+	}
+	for _, p := range s.BusyProcs {
+		// The usage varies between samples, so it is not part of the
+		// warning, which is reported once per run.
+		ws = append(ws, fmt.Sprintf("process %s (pid %d) is using more than %.0f%% of a CPU",
+			p.Name, p.PID, maxQuietProcCPU))
+	}
+	return ws
+}
+
+// envMonitor samples the environment before and periodically during a run.
+type envMonitor struct {
+	stopC    chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+
+	mu struct {
+		sync.Mutex
+		report envReport
+		seen   map[string]struct{}
+	}
+}
+
+// startEnvMonitor samples the environment and starts sampling it periodically.
+// It returns the warnings of the initial sample.
+func startEnvMonitor() (*envMonitor, []string) {
+	m := &envMonitor{stopC: make(chan struct{})}
+	m.mu.seen = make(map[string]struct{})
+	ws := m.add(sampleEnv(), true /* checkLoad */)
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		t := time.NewTicker(envMonitorInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-m.stopC:
+				return
+			case <-t.C:
+				m.add(sampleEnv(), false /* checkLoad */)
+			}
+		}
+	}()
+	return m, ws
+}
+
+func (m *envMonitor) add(s envSample, checkLoad bool) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mu.report.Samples = append(m.mu.report.Samples, s)
+	ws := s.warnings(checkLoad)
+	for _, w := range ws {
+		if _, ok := m.mu.seen[w]; !ok {
+			m.mu.seen[w] = struct{}{}
+			m.mu.report.Warnings = append(m.mu.report.Warnings, w)
+		}
+	}
+	return ws
+}
+
+// stop takes a final sample and returns the report of the observed conditions.
+// It may be called more than once; the final sample is only taken once.
+func (m *envMonitor) stop() *envReport {
+	m.stopOnce.Do(func() {
+		close(m.stopC)
+		m.wg.Wait()
+		m.add(sampleEnv(), false /* checkLoad */)
+	})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := m.mu.report
+	first, last := r.Samples[0], r.Samples[len(r.Samples)-1]
+	if first.Throttles >= 0 && last.Throttles > first.Throttles {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("CPUs were thermally throttled %d times during the run",
+			last.Throttles-first.Throttles))
+	}
+	return &r
+}
+
+// merge returns the report of a run that was resumed, combining the conditions
+// observed before and after it was interrupted. r may be nil.
+func (r *envReport) merge(o *envReport) *envReport {
+	if r == nil {
+		return o
+	}
+	m := &envReport{Samples: append(r.Samples, o.Samples...)}
+	seen := make(map[string]struct{})
+	for _, w := range append(r.Warnings, o.Warnings...) {
+		if _, ok := seen[w]; !ok {
+			seen[w] = struct{}{}
+			m.Warnings = append(m.Warnings, w)
+		}
+	}
+	return m
+}
+
+// formatEnvText writes the observed conditions and any warnings about them.
+func formatEnvText(w io.Writer, r *envReport) {
+	if r == nil || len(r.Samples) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "environment: %s\n", r.summary())
+	for _, warn := range r.Warnings {
+		fmt.Fprintf(w, "warning: %s\n", warn)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProcStat(t *testing.T) {
+	testCases := []struct {
+		line string
+		exp  procStat
+		ok   bool
+	}{
+		{
+			line: "1234 (go) S 1 1234 1234 0 -1 4194304 100 0 0 0 250 30 0 0 20 0 8 0 100 0 0\n",
+			exp:  procStat{name: "go", ppid: 1, ticks: 280},
+			ok:   true,
+		},
+		{
+			// Names can contain spaces and parentheses.
+			line: "42 (a (b) c) R 7 42 42 0 -1 0 0 0 0 0 5 6 0 0 20 0 1 0 100 0 0\n",
+			exp:  procStat{name: "a (b) c", ppid: 7, ticks: 11},
+			ok:   true,
+		},
+		{
+			line: "42 (truncated) R 7 42",
+		},
+		{
+			line: "garbage",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.line, func(t *testing.T) {
+			st, ok := parseProcStat(tc.line)
+			if ok != tc.ok {
+				t.Fatalf("expected ok=%t, found %t", tc.ok, ok)
+			}
+			if st != tc.exp {
+				t.Errorf("expected %+v, found %+v", tc.exp, st)
+			}
+		})
+	}
+}
+
+func TestEnvSampleWarnings(t *testing.T) {
+	s := envSample{
+		LoadAvg:   2.5,
+		Governors: []string{"performance", "powersave"},
+		Turbo:     "on",
+		Throttles: -1,
+		BusyProcs: []busyProc{{PID: 7, Name: "cc1", CPU: 97}},
+	}
+	exp := []string{
+		`load average is 2.50`,
+		`CPU frequency governor is "powersave", not "performance"`,
+		`turbo boost is enabled`,
+		`process cc1 (pid 7) is using more than 10% of a CPU`,
+	}
+	if ws := s.warnings(true /* checkLoad */); !reflect.DeepEqual(ws, exp) {
+		t.Errorf("expected %q, found %q", exp, ws)
+	}
+	if ws := s.warnings(false /* checkLoad */); !reflect.DeepEqual(ws, exp[1:]) {
+		t.Errorf("expected %q, found %q", exp[1:], ws)
+	}
+
+	quiet := envSample{LoadAvg: 0.1, Governors: []string{"performance"}, Turbo: "off"}
+	if ws := quiet.warnings(true /* checkLoad */); len(ws) != 0 {
+		t.Errorf("expected no warnings, found %q", ws)
+	}
+}
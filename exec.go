@@ -2,25 +2,35 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"os"
 	"os/exec"
+	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
+// cancelGracePeriod is how long a process is given to exit after being asked
+// to terminate due to context cancellation before it is killed.
+const cancelGracePeriod = 5 * time.Second
+
 // capture executes the command specified by args and returns its stdout. If
 // the process exits with a failing exit code, capture instead returns an error
 // which includes the process's stderr.
-func capture(args ...string) (string, error) {
-	var cmd *exec.Cmd
+func capture(ctx context.Context, args ...string) (string, error) {
+	return captureIn(ctx, "", args...)
+}
+
+// captureIn is like capture, but runs the command in the provided directory.
+// If dir is empty, the command runs in the current directory.
+func captureIn(ctx context.Context, dir string, args ...string) (string, error) {
 	if len(args) == 0 {
 		panic("capture called with no arguments")
-	} else if len(args) == 1 {
-		cmd = exec.Command(args[0])
-	} else {
-		cmd = exec.Command(args[0], args[1:]...)
 	}
+	cmd := command(ctx, args...)
+	cmd.Dir = dir
 	out, err := cmd.Output()
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
@@ -36,8 +46,8 @@ func capture(args ...string) (string, error) {
 // with a failing exit code, run returns a generic "process exited with
 // status..." error, as the process has likely written an error message to
 // stderr.
-func spawn(args ...string) error {
-	return spawnWith(os.Stdin, os.Stdout, os.Stderr, args...)
+func spawn(ctx context.Context, args ...string) error {
+	return spawnWith(ctx, os.Stdin, os.Stdout, os.Stderr, args...)
 }
 
 // spawnWith executes the command specified by args using the provided reader
@@ -45,16 +55,20 @@ func spawn(args ...string) error {
 // stdin, stdout, and stderr streams. If the process exits with a failing exit
 // code, run returns a generic "process exited with status..." error, as the
 // process has likely written an error message to stderr.
-func spawnWith(in io.Reader, out, err io.Writer, args ...string) error {
-	var cmd *exec.Cmd
+func spawnWith(ctx context.Context, in io.Reader, out, err io.Writer, args ...string) error {
 	if len(args) == 0 {
 		panic("spawn called with no arguments")
-	} else if len(args) == 1 {
-		cmd = exec.Command(args[0])
-	} else {
-		cmd = exec.Command(args[0], args[1:]...)
 	}
+	cmd := command(ctx, args...)
+	cmd.Env = spawnEnv()
+	cmd.Stdin = in
+	cmd.Stdout = out
+	cmd.Stderr = err
+	return cmd.Run()
+}
 
+// spawnEnv returns the environment for spawned processes.
+func spawnEnv() []string {
 	// Ensure that GODEBUG=[...,]runtimecontentionstacks=1 is set to improve
 	// mutex profiles.
 	env := os.Environ()
@@ -63,10 +77,22 @@ func spawnWith(in io.Reader, out, err io.Writer, args ...string) error {
 		envGodebug += ","
 	}
 	envGodebug += "runtimecontentionstacks=1"
-	cmd.Env = append(env, "GODEBUG="+envGodebug)
+	return append(env, "GODEBUG="+envGodebug)
+}
 
-	cmd.Stdin = in
-	cmd.Stdout = out
-	cmd.Stderr = err
-	return cmd.Run()
+// command constructs the command specified by args. If the context is canceled
+// while the command is running, the process is sent a SIGTERM and, if it has
+// not exited after a grace period, is killed.
+func command(ctx context.Context, args ...string) *exec.Cmd {
+	var cmd *exec.Cmd
+	if len(args) == 1 {
+		cmd = exec.CommandContext(ctx, args[0])
+	} else {
+		cmd = exec.CommandContext(ctx, args[0], args[1:]...)
+	}
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = cancelGracePeriod
+	return cmd
 }
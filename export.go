@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nvanbenschoten/benchdiff/google"
+	"golang.org/x/perf/benchstat"
+)
+
+// report is the outcome of a benchmark run.
+type report struct {
+	md       runMetadata
+	tables   []*benchstat.Table
+	failures []failureSummary
+	// profileDiffs are the differential profiles of the new ref against the
+	// old ref, for each profile type that was recorded.
+	profileDiffs []profileDiff
+	// culprits are the likely culprits of the significant regressions.
+	culprits []culpritGroup
+}
+
+// exporter is a destination for benchmark comparison results.
+type exporter interface {
+	// init prepares the exporter for use. Exporters that talk to external
+	// services should validate their credentials here, as init is called
+	// before any benchmarks are run so that problems are detected early.
+	init(ctx context.Context) error
+	// export writes the report to the destination. If the destination has a
+	// location that the user should be pointed at, like a URL or a file path,
+	// export returns it.
+	export(ctx context.Context, w io.Writer, r *report) (string, error)
+}
+
+// exporterSpec registers an exporter behind a command-line flag.
+type exporterSpec struct {
+	// flag is the name of the boolean flag that selects the exporter.
+	flag string
+	// link describes the location returned by the exporter, if any.
+	link string
+	new  func() exporter
+}
+
+// exporters is the registry of all non-default output formats. The text
+// exporter is used when none of these are selected.
+var exporters = []exporterSpec{
+	{flag: "csv", new: func() exporter { return csvExporter{} }},
+	{flag: "html", new: func() exporter { return htmlExporter{} }},
+	{flag: "sheets", link: "generated sheet", new: func() exporter { return &sheetsExporter{} }},
+}
+
+// textExporter outputs the benchmark comparison in a text format.
+//
+// Example:
+//
+//	name         old time/op    new time/op    delta
+//	String-8       68.6ns ± 0%    68.2ns ± 0%   ~     (p=1.000 n=1+1)
+//	FromBytes-8    4.92ns ± 0%    4.97ns ± 0%   ~     (p=1.000 n=1+1)
+type textExporter struct{}
+
+func (textExporter) init(context.Context) error { return nil }
+
+func (textExporter) export(_ context.Context, w io.Writer, r *report) (string, error) {
+	benchstat.FormatText(w, r.tables)
+	formatFailuresText(w, r.failures)
+	formatCulpritsText(w, r.culprits)
+	formatEnvText(w, r.md.Env)
+	formatProfileDiffsText(w, r.profileDiffs)
+	formatHostText(w, r.md)
+	return "", nil
+}
+
+// csvExporter outputs the benchmark comparison in a csv format.
+//
+// Example:
+//
+//	name,old time/op (ns/op),±,new time/op (ns/op),±,delta,±
+//	String-8,6.82000E+01,0%,6.76000E+01,0%,~,(p=1.000 n=1+1)
+//	FromBytes-8,5.01000E+00,0%,4.95000E+00,0%,~,(p=1.000 n=1+1)
+//
+//	failures,pkg,old,new,message
+//	BenchmarkFoo,example.com/pkg/foo,0,3,foo_test.go:12: some message
+//
+//	environment,load 0.12-0.98, governor performance, turbo off
+//	warning,process foo (pid 123) is using more than 10% of a CPU
+//
+//	profile diff,report,path
+//	cpu,top,/path/to/artifacts/cpu_diff.top.txt
+//
+//	host,value
+//	go,go1.21.4 linux/amd64
+//	cores,8
+type csvExporter struct{}
+
+func (csvExporter) init(context.Context) error { return nil }
+
+func (csvExporter) export(_ context.Context, w io.Writer, r *report) (string, error) {
+	// If norange is true, suppress the range information for each data item.
+	// If norange is false, insert a "±" in the appropriate columns of the header row.
+	norange := false
+	benchstat.FormatCSV(w, r.tables, norange)
+	cw := csv.NewWriter(w)
+	if len(r.failures) > 0 {
+		fmt.Fprintln(w)
+		_ = cw.Write([]string{"failures", "pkg", "old", "new", "message"})
+		for _, f := range r.failures {
+			_ = cw.Write([]string{
+				f.name(), f.pkg, strconv.Itoa(f.old), strconv.Itoa(f.new), f.message,
+			})
+		}
+		cw.Flush()
+	}
+	if env := r.md.Env; env != nil && len(env.Samples) > 0 {
+		fmt.Fprintln(w)
+		_ = cw.Write([]string{"environment", env.summary()})
+		for _, warn := range env.Warnings {
+			_ = cw.Write([]string{"warning", warn})
+		}
+		cw.Flush()
+	}
+	if len(r.profileDiffs) > 0 {
+		fmt.Fprintln(w)
+		_ = cw.Write([]string{"profile diff", "report", "path"})
+		for _, d := range r.profileDiffs {
+			for _, f := range d.files() {
+				_ = cw.Write([]string{d.name(), f[0], f[1]})
+			}
+		}
+		cw.Flush()
+	}
+	if r.md.Host != nil {
+		fmt.Fprintln(w)
+		_ = cw.Write([]string{"host", "value"})
+		for _, f := range r.md.Host.fields(r.md.OldRef, r.md.NewRef) {
+			_ = cw.Write([]string{f.name, f.value})
+		}
+		cw.Flush()
+	}
+	return "", cw.Error()
+}
+
+// htmlExporter outputs the benchmark comparison in an HTML format.
+//
+// Example:
+//
+//	<table class='benchstat oldnew'>
+//	<tr class='configs'><th><th>old<th>new
+//	<tbody>
+//	<tr><th><th colspan='2' class='metric'>time/op<th>delta
+//	<tr class='unchanged'><td>String-8<td>70.1ns ± 0%<td>69.6ns ± 0%<td class='nodelta'>~<td class='note'>(p=1.000 n=1&#43;1)
+//	<tr class='unchanged'><td>FromBytes-8<td>5.42ns ± 0%<td>5.05ns ± 0%<td class='nodelta'>~<td class='note'>(p=1.000 n=1&#43;1)
+//	<tr><td>&nbsp;
+//	</tbody>
+//	</table>
+//
+// Failed benchmarks are listed in a separate table of class 'failures', and the
+// observed environment in one of class 'env'. Differential profiles are linked
+// from a table of class 'profiles', and the host metadata is listed in a table
+// of class 'host'.
+type htmlExporter struct{}
+
+func (htmlExporter) init(context.Context) error { return nil }
+
+func (htmlExporter) export(_ context.Context, w io.Writer, r *report) (string, error) {
+	var buf bytes.Buffer
+	benchstat.FormatHTML(&buf, r.tables)
+	if len(r.failures) > 0 {
+		buf.WriteString("<table class='benchstat failures'>\n")
+		buf.WriteString("<tr><th>failures<th>pkg<th>old<th>new<th>message\n")
+		for _, f := range r.failures {
+			fmt.Fprintf(&buf, "<tr><td>%s<td>%s<td>%d<td>%d<td class='note'>%s\n",
+				html.EscapeString(f.name()), html.EscapeString(f.pkg), f.old, f.new,
+				html.EscapeString(f.message))
+		}
+		buf.WriteString("</table>\n")
+	}
+	if env := r.md.Env; env != nil && len(env.Samples) > 0 {
+		buf.WriteString("<table class='benchstat env'>\n")
+		fmt.Fprintf(&buf, "<tr><th>environment<td>%s\n", html.EscapeString(env.summary()))
+		for _, warn := range env.Warnings {
+			fmt.Fprintf(&buf, "<tr><th>warning<td class='note'>%s\n", html.EscapeString(warn))
+		}
+		buf.WriteString("</table>\n")
+	}
+	if len(r.profileDiffs) > 0 {
+		buf.WriteString("<table class='benchstat profiles'>\n")
+		buf.WriteString("<tr><th>profile diff<th>reports\n")
+		for _, d := range r.profileDiffs {
+			fmt.Fprintf(&buf, "<tr><td>%s<td>", html.EscapeString(d.name()))
+			for i, f := range d.files() {
+				if i > 0 {
+					buf.WriteString(" ")
+				}
+				path, err := filepath.Abs(f[1])
+				if err != nil {
+					return "", err
+				}
+				u := url.URL{Scheme: "file", Path: path}
+				fmt.Fprintf(&buf, "<a href='%s'>%s</a>", html.EscapeString(u.String()), html.EscapeString(f[0]))
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString("</table>\n")
+	}
+	if r.md.Host != nil {
+		buf.WriteString("<table class='benchstat host'>\n")
+		for _, f := range r.md.Host.fields(r.md.OldRef, r.md.NewRef) {
+			fmt.Fprintf(&buf, "<tr><th>%s<td>%s\n", html.EscapeString(f.name), html.EscapeString(f.value))
+		}
+		buf.WriteString("</table>\n")
+	}
+	_, err := io.Copy(w, &buf)
+	return "", err
+}
+
+// sheetsExporter outputs the benchmark comparison to a new Google Sheets
+// spreadsheet and returns the sheet's URL. The comparison is also written as
+// text. The host metadata is added to the spreadsheet as an info sheet.
+//
+// Example:
+//
+//	name         old time/op    new time/op    delta
+//	String-8       68.6ns ± 0%    68.2ns ± 0%   ~     (p=1.000 n=1+1)
+//	FromBytes-8    4.92ns ± 0%    4.97ns ± 0%   ~     (p=1.000 n=1+1)
+//
+//	generated sheet: https://docs.google.com/spreadsheets/...
+type sheetsExporter struct {
+	srv *google.Service
+}
+
+func (e *sheetsExporter) init(ctx context.Context) error {
+	var err error
+	e.srv, err = google.New(ctx)
+	return err
+}
+
+func (e *sheetsExporter) export(ctx context.Context, w io.Writer, r *report) (string, error) {
+	// When outputting a Google sheet, also output as text first.
+	if _, err := (textExporter{}).export(ctx, w, r); err != nil {
+		return "", err
+	}
+
+	sheetName := fmt.Sprintf("benchdiff: %s (%s -> %s)",
+		strings.Join(r.md.PkgFilter, " "), r.md.OldRef, r.md.NewRef)
+	var info [][2]string
+	if r.md.Host != nil {
+		for _, f := range r.md.Host.fields(r.md.OldRef, r.md.NewRef) {
+			info = append(info, [2]string{f.name, f.value})
+		}
+	}
+	return e.srv.CreateSheet(ctx, sheetName, r.tables, info)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/perf/benchstat"
+)
+
+func TestExporters(t *testing.T) {
+	exp := []struct {
+		flag string
+		link string
+		typ  exporter
+	}{
+		{"csv", "", csvExporter{}},
+		{"html", "", htmlExporter{}},
+		{"sheets", "generated sheet", &sheetsExporter{}},
+	}
+	if len(exporters) != len(exp) {
+		t.Fatalf("expected %d exporters, found %d", len(exp), len(exporters))
+	}
+	for i, e := range exporters {
+		if e.flag != exp[i].flag || e.link != exp[i].link {
+			t.Errorf("%d: expected flag %q and link %q, found %q and %q",
+				i, exp[i].flag, exp[i].link, e.flag, e.link)
+		}
+		if typ := reflect.TypeOf(e.new()); typ != reflect.TypeOf(exp[i].typ) {
+			t.Errorf("%s: expected a %v, found a %v", e.flag, reflect.TypeOf(exp[i].typ), typ)
+		}
+	}
+}
+
+// testReport returns a report with a benchstat table and every optional
+// section, some of whose values need to be escaped.
+func testReport(t *testing.T) *report {
+	t.Helper()
+	c := &benchstat.Collection{Alpha: 0.05, DeltaTest: benchstat.UTest, Order: benchstat.ByName}
+	c.AddConfig("old", []byte("BenchmarkString-8 1 68 ns/op\nBenchmarkString-8 1 70 ns/op\n"))
+	c.AddConfig("new", []byte("BenchmarkString-8 1 69 ns/op\nBenchmarkString-8 1 71 ns/op\n"))
+	return &report{
+		md: runMetadata{
+			OldRef: "old",
+			NewRef: "new",
+			Env: &envReport{
+				Samples:  []envSample{{LoadAvg: 0.5, Turbo: "off", Throttles: -1}},
+				Warnings: []string{"process <foo> is busy"},
+			},
+			Host: &hostInfo{GoVersion: "go1.21.4 linux/amd64", GOARCH: "amd64", Cores: 8},
+		},
+		tables: c.Tables(),
+		failures: []failureSummary{{
+			failureKey: failureKey{pkg: "example.com/foo", benchmark: "BenchmarkFoo"},
+			message:    "foo_test.go:12: a < b",
+			new:        3,
+		}},
+		profileDiffs: []profileDiff{{
+			profilePair: profilePair{profType: "cpu", old: "/a/old.prof", new: "/a/new.prof"},
+			topPath:     "/a/cpu_diff.top.txt",
+		}},
+	}
+}
+
+func TestCSVExporter(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := (csvExporter{}).export(context.Background(), &buf, testReport(t)); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, exp := range []string{
+		"name,old time/op (ns/op),±,new time/op (ns/op),±,delta,±\n",
+		"String-8,",
+		"\nfailures,pkg,old,new,message\n" +
+			"BenchmarkFoo,example.com/foo,0,3,foo_test.go:12: a < b\n",
+		"\nenvironment,\"load 0.50-0.50, turbo off\"\n" +
+			"warning,process <foo> is busy\n",
+		"\nprofile diff,report,path\n" +
+			"cpu,old profile,/a/old.prof\n" +
+			"cpu,new profile,/a/new.prof\n" +
+			"cpu,top,/a/cpu_diff.top.txt\n",
+		"\nhost,value\n" +
+			"go,go1.21.4 linux/amd64\n" +
+			"GOARCH,amd64\n" +
+			"cpu model,\n" +
+			"cores,8\n",
+	} {
+		if !strings.Contains(out, exp) {
+			t.Errorf("expected output to contain %q, found:\n%s", exp, out)
+		}
+	}
+}
+
+func TestHTMLExporter(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := (htmlExporter{}).export(context.Background(), &buf, testReport(t)); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, exp := range []string{
+		"<table class='benchstat oldnew'>\n",
+		"<td>String-8<td>",
+		"<table class='benchstat failures'>\n" +
+			"<tr><th>failures<th>pkg<th>old<th>new<th>message\n" +
+			"<tr><td>BenchmarkFoo<td>example.com/foo<td>0<td>3<td class='note'>foo_test.go:12: a &lt; b\n" +
+			"</table>\n",
+		"<table class='benchstat env'>\n" +
+			"<tr><th>environment<td>load 0.50-0.50, turbo off\n" +
+			"<tr><th>warning<td class='note'>process &lt;foo&gt; is busy\n" +
+			"</table>\n",
+		"<table class='benchstat profiles'>\n" +
+			"<tr><th>profile diff<th>reports\n" +
+			"<tr><td>cpu<td><a href='file:///a/old.prof'>old profile</a> " +
+			"<a href='file:///a/new.prof'>new profile</a> " +
+			"<a href='file:///a/cpu_diff.top.txt'>top</a>\n" +
+			"</table>\n",
+		"<table class='benchstat host'>\n" +
+			"<tr><th>go<td>go1.21.4 linux/amd64\n" +
+			"<tr><th>GOARCH<td>amd64\n" +
+			"<tr><th>cpu model<td>\n" +
+			"<tr><th>cores<td>8\n" +
+			"</table>\n",
+	} {
+		if !strings.Contains(out, exp) {
+			t.Errorf("expected output to contain %q, found:\n%s", exp, out)
+		}
+	}
+}
+
+func TestExportersOmitEmptySections(t *testing.T) {
+	r := testReport(t)
+	r.md, r.failures, r.profileDiffs = runMetadata{}, nil, nil
+	for _, tc := range []struct {
+		e        exporter
+		sections []string
+	}{
+		{csvExporter{}, []string{"failures,", "environment,", "profile diff,", "host,"}},
+		{htmlExporter{}, []string{"failures'", "env'", "profiles'", "host'"}},
+	} {
+		var buf bytes.Buffer
+		if _, err := tc.e.export(context.Background(), &buf, r); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range tc.sections {
+			if strings.Contains(buf.String(), s) {
+				t.Errorf("%T: expected no %q section, found:\n%s", tc.e, s, buf.String())
+			}
+		}
+	}
+}
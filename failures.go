@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+)
+
+// benchFailure is a single failure of a benchmark, as reported in the output
+// of a test binary.
+type benchFailure struct {
+	pkg string
+	// benchmark is the name of the failed benchmark, without its GOMAXPROCS
+	// suffix. It is empty if the failure could not be attributed to a
+	// benchmark, in which case the whole package is considered failed.
+	benchmark string
+	// kind is either "fail", for benchmarks that called b.Fatal or b.Error or
+	// that were failed by benchdiff's watchdog, or "panic".
+	kind    string
+	message string
+}
+
+// failureKey identifies a benchmark across iterations and refs.
+type failureKey struct {
+	pkg, benchmark string
+}
+
+// failureSummary describes the failures of a benchmark across all iterations
+// of both refs.
+type failureSummary struct {
+	failureKey
+	kind    string
+	message string // of the first failure
+	// old and new are the number of iterations in which the benchmark failed
+	// on the old and new ref, respectively.
+	old, new int
+}
+
+// oneSided returns whether the benchmark failed on only one of the refs.
+func (s failureSummary) oneSided() bool {
+	return (s.old == 0) != (s.new == 0)
+}
+
+// name returns the name of the benchmark, or of the package if the failure was
+// not attributed to a benchmark.
+func (s failureSummary) name() string {
+	if s.benchmark == "" {
+		return s.pkg
+	}
+	return s.benchmark
+}
+
+// parseFailures scans the output of one or more invocations of test binaries
+// for benchmark failures. Failures are reported by the testing package as
+//
+//	--- FAIL: BenchmarkFoo
+//	    foo_test.go:12: some message
+//
+// and panics as
+//
+//	BenchmarkFoo-8   	panic: some message
+//
+//	goroutine 6 [running]:
+//	...
+//
+// Each invocation's output starts with a header naming its package, which the
+// failures are attributed to.
+func parseFailures(r io.Reader) ([]benchFailure, error) {
+	var fs []benchFailure
+	var pkg string
+	// last is the failure whose message or stack trace is being read.
+	var last *benchFailure
+	var inPanic, inFail bool
+
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case strings.HasPrefix(line, "goos: "), strings.HasPrefix(line, "pkg: "):
+			// The start of the next invocation. A panic ends the invocation
+			// that it occurred in.
+			if p := strings.TrimPrefix(line, "pkg: "); p != line {
+				pkg = p
+			}
+			inPanic, inFail = false, false
+			continue
+		case inPanic:
+			// Attribute the panic to the benchmark in its stack trace if it
+			// was not printed before the panic.
+			if last.benchmark == "" {
+				if m := benchFrameRE.FindStringSubmatch(line); m != nil {
+					last.benchmark = m[1]
+				}
+			}
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if name := strings.TrimPrefix(trimmed, "--- FAIL: "); name != trimmed {
+			fields := strings.Fields(name)
+			if len(fields) == 0 {
+				continue
+			}
+			f := benchFailure{pkg: pkg, kind: "fail"}
+			if strings.HasPrefix(fields[0], "Benchmark") {
+				f.benchmark = trimProcsSuffix(fields[0])
+			}
+			fs = append(fs, f)
+			last, inFail = &fs[len(fs)-1], true
+			continue
+		}
+		if i := strings.Index(line, "panic: "); i >= 0 {
+			prefix := strings.TrimSpace(line[:i])
+			if prefix == "" || strings.HasPrefix(prefix, "Benchmark") {
+				f := benchFailure{pkg: pkg, kind: "panic", message: line[i:]}
+				if prefix != "" {
+					f.benchmark = trimProcsSuffix(strings.Fields(prefix)[0])
+				}
+				fs = append(fs, f)
+				last, inPanic, inFail = &fs[len(fs)-1], true, false
+				continue
+			}
+		}
+		if inFail {
+			// The indented lines following a failure are its log output.
+			if strings.HasPrefix(line, "    ") {
+				if last.message == "" {
+					last.message = trimmed
+				}
+				continue
+			}
+			inFail = false
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrap(err, "parsing benchmark failures")
+	}
+	return fs, nil
+}
+
+// summarizeFailures combines the failures of the old and new refs, sorted by
+// package and benchmark.
+func summarizeFailures(oldFs, newFs []benchFailure) []failureSummary {
+	byKey := make(map[failureKey]*failureSummary)
+	var keys []failureKey
+	add := func(f benchFailure, isNew bool) {
+		k := failureKey{pkg: f.pkg, benchmark: f.benchmark}
+		s, ok := byKey[k]
+		if !ok {
+			s = &failureSummary{failureKey: k, kind: f.kind, message: f.message}
+			byKey[k] = s
+			keys = append(keys, k)
+		}
+		if isNew {
+			s.new++
+		} else {
+			s.old++
+		}
+	}
+	for _, f := range oldFs {
+		add(f, false)
+	}
+	for _, f := range newFs {
+		add(f, true)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].pkg != keys[j].pkg {
+			return keys[i].pkg < keys[j].pkg
+		}
+		return keys[i].benchmark < keys[j].benchmark
+	})
+	res := make([]failureSummary, len(keys))
+	for i, k := range keys {
+		res[i] = *byKey[k]
+	}
+	return res
+}
+
+// excludeFailures returns a copy of the benchmark output in r without the
+// results of the benchmarks that failed, so that they are not compared.
+func excludeFailures(r io.Reader, failures []failureSummary) (io.Reader, error) {
+	failed := make(map[failureKey]struct{}, len(failures))
+	for _, f := range failures {
+		failed[f.failureKey] = struct{}{}
+	}
+	isFailed := func(pkg, benchmark string) bool {
+		if _, ok := failed[failureKey{pkg: pkg}]; ok {
+			return true
+		}
+		// A failure of a benchmark also fails all of its sub-benchmarks.
+		for {
+			if _, ok := failed[failureKey{pkg: pkg, benchmark: benchmark}]; ok {
+				return true
+			}
+			i := strings.LastIndexByte(benchmark, '/')
+			if i < 0 {
+				return false
+			}
+			benchmark = benchmark[:i]
+		}
+	}
+
+	var buf bytes.Buffer
+	var pkg string
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		line := s.Text()
+		if p := strings.TrimPrefix(line, "pkg: "); p != line {
+			pkg = p
+		}
+		if strings.HasPrefix(line, "Benchmark") {
+			if fields := strings.Fields(line); isFailed(pkg, trimProcsSuffix(fields[0])) {
+				continue
+			}
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// formatFailuresText writes the failures as a table, like:
+//
+//	failures     pkg                  old  new  message
+//	BenchmarkFoo example.com/pkg/foo  0    3    foo_test.go:12: some message
+func formatFailuresText(w io.Writer, failures []failureSummary) {
+	if len(failures) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "failures\tpkg\told\tnew\tmessage")
+	for _, f := range failures {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%.80s\n", f.name(), f.pkg, f.old, f.new, f.message)
+	}
+	_ = tw.Flush()
+}
+
+// checkFailures returns an error if any benchmark failed on only one of the
+// refs, which indicates that the new ref broke (or fixed) the benchmark.
+func checkFailures(failures []failureSummary) error {
+	for _, f := range failures {
+		if !f.oneSided() {
+			continue
+		}
+		side, n := "new", f.new
+		if f.old != 0 {
+			side, n = "old", f.old
+		}
+		return errors.Errorf("%s in %s failed %d time(s) on the %s ref only", f.name(), f.pkg, n, side)
+	}
+	return nil
+}
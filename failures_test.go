@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const failuresOutput = `goos: linux
+goarch: amd64
+pkg: example.com/a
+BenchmarkFoo-8   	1000000000	         0.8151 ns/op	       0 B/op	       0 allocs/op
+--- FAIL: BenchmarkBar-8
+    bar_test.go:12: bar is broken
+    bar_test.go:13: still broken
+BenchmarkBaz/n=1-8   	1000000000	         0.8151 ns/op	       0 B/op	       0 allocs/op
+BenchmarkBaz/n=2-8   	1000000000	         0.8151 ns/op	       0 B/op	       0 allocs/op
+--- FAIL: BenchmarkBaz/n=3
+    baz_test.go:20: n too large
+goos: linux
+goarch: amd64
+pkg: example.com/b
+BenchmarkQux-8   	panic: qux exploded
+
+goroutine 6 [running]:
+example.com/b.BenchmarkQux(0xc000130008)
+	/b/qux_test.go:8 +0x1d
+goos: linux
+goarch: amd64
+pkg: example.com/c
+panic: init failed
+
+goroutine 1 [running]:
+example.com/c.init.0()
+	/c/c.go:3 +0x1d
+goroutine 6 [running]:
+example.com/c.BenchmarkQuux(0xc000130008)
+	/c/quux_test.go:8 +0x1d
+`
+
+func TestParseFailures(t *testing.T) {
+	fs, err := parseFailures(strings.NewReader(failuresOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []benchFailure{
+		{pkg: "example.com/a", benchmark: "BenchmarkBar", kind: "fail", message: "bar_test.go:12: bar is broken"},
+		{pkg: "example.com/a", benchmark: "BenchmarkBaz/n=3", kind: "fail", message: "baz_test.go:20: n too large"},
+		{pkg: "example.com/b", benchmark: "BenchmarkQux", kind: "panic", message: "panic: qux exploded"},
+		{pkg: "example.com/c", benchmark: "BenchmarkQuux", kind: "panic", message: "panic: init failed"},
+	}
+	if !reflect.DeepEqual(fs, exp) {
+		t.Errorf("expected %+v, found %+v", exp, fs)
+	}
+}
+
+func TestSummarizeFailures(t *testing.T) {
+	foo := benchFailure{pkg: "example.com/a", benchmark: "BenchmarkFoo", kind: "fail"}
+	bar := benchFailure{pkg: "example.com/a", benchmark: "BenchmarkBar", kind: "panic"}
+	sums := summarizeFailures([]benchFailure{foo, foo}, []benchFailure{foo, bar})
+	exp := []failureSummary{
+		{failureKey: failureKey{"example.com/a", "BenchmarkBar"}, kind: "panic", new: 1},
+		{failureKey: failureKey{"example.com/a", "BenchmarkFoo"}, kind: "fail", old: 2, new: 1},
+	}
+	if !reflect.DeepEqual(sums, exp) {
+		t.Errorf("expected %+v, found %+v", exp, sums)
+	}
+	if err := checkFailures(sums); err == nil {
+		t.Errorf("expected error for one-sided failure of BenchmarkBar")
+	}
+	if err := checkFailures(sums[1:]); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestExcludeFailures(t *testing.T) {
+	const out = `pkg: example.com/a
+BenchmarkFoo-8   	100	         1 ns/op
+BenchmarkBar/n=1-8   	100	         1 ns/op
+BenchmarkBarn-8   	100	         1 ns/op
+pkg: example.com/b
+BenchmarkBar/n=1-8   	100	         1 ns/op
+pkg: example.com/c
+BenchmarkFoo-8   	100	         1 ns/op
+`
+	failures := []failureSummary{
+		{failureKey: failureKey{"example.com/a", "BenchmarkBar"}},
+		{failureKey: failureKey{"example.com/c", ""}},
+	}
+	r, err := excludeFailures(strings.NewReader(out), failures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const exp = `pkg: example.com/a
+BenchmarkFoo-8   	100	         1 ns/op
+BenchmarkBarn-8   	100	         1 ns/op
+pkg: example.com/b
+BenchmarkBar/n=1-8   	100	         1 ns/op
+pkg: example.com/c
+`
+	if string(b) != exp {
+		t.Errorf("expected:\n%s\nfound:\n%s", exp, b)
+	}
+}
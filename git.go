@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"os"
 	"strconv"
 	"strings"
@@ -9,8 +10,8 @@ import (
 )
 
 // getRefAsSHA returns the provided git ref as a SHA.
-func getRefAsSHA(ref string) (string, error) {
-	ref, err := capture("git", "rev-parse", ref)
+func getRefAsSHA(ctx context.Context, ref string) (string, error) {
+	ref, err := capture(ctx, "git", "rev-parse", ref)
 	if err != nil {
 		return "", errors.Wrap(err, "getting git ref as sha")
 	}
@@ -19,8 +20,8 @@ func getRefAsSHA(ref string) (string, error) {
 
 // getCurRef returns the active git ref in the current working directory's
 // repository.
-func getCurRef() (string, error) {
-	ref, err := getRefAsSHA("HEAD")
+func getCurRef(ctx context.Context) (string, error) {
+	ref, err := getRefAsSHA(ctx, "HEAD")
 	if err != nil {
 		return "", errors.Wrap(err, "getting current git ref")
 	}
@@ -29,8 +30,8 @@ func getCurRef() (string, error) {
 
 // getCurRef returns the previous git ref in the current working directory's
 // repository.
-func getPrevRef(ref string) (string, error) {
-	ref, err := getRefAsSHA(ref + "~")
+func getPrevRef(ctx context.Context, ref string) (string, error) {
+	ref, err := getRefAsSHA(ctx, ref+"~")
 	if err != nil {
 		return "", errors.Wrap(err, "getting previous git ref")
 	}
@@ -40,8 +41,8 @@ func getPrevRef(ref string) (string, error) {
 // getCurSymbolicRef returns the active git symbolic ref in the current working
 // directory's repository. If a symbolic reference could not be found, returns
 // false instead.
-func getCurSymbolicRef() (string, bool, error) {
-	ref, err := capture("git", "symbolic-ref", "HEAD")
+func getCurSymbolicRef(ctx context.Context) (string, bool, error) {
+	ref, err := capture(ctx, "git", "symbolic-ref", "HEAD")
 	if err != nil {
 		if strings.Contains(err.Error(), "not a symbolic ref") {
 			return "", false, nil
@@ -54,8 +55,8 @@ func getCurSymbolicRef() (string, bool, error) {
 
 // checkValidRef determines whether the provided git ref is valid in the current
 // working directory's repository.
-func checkValidRef(ref string) (bool, error) {
-	_, err := capture("git", "cat-file", "-t", ref)
+func checkValidRef(ctx context.Context, ref string) (bool, error) {
+	_, err := capture(ctx, "git", "cat-file", "-t", ref)
 	if err != nil {
 		if strings.Contains(err.Error(), "Not a valid object name") {
 			return false, nil
@@ -66,7 +67,7 @@ func checkValidRef(ref string) (bool, error) {
 }
 
 // shortenRef attempts to shorten the git ref.
-func shortenRef(ref string) string {
+func shortenRef(ctx context.Context, ref string) string {
 	if len(ref) <= 7 {
 		return ref
 	}
@@ -75,7 +76,7 @@ func shortenRef(ref string) string {
 		// Not a SHA.
 		return ref
 	}
-	if ok, err := checkValidRef(shortRef); ok && err == nil {
+	if ok, err := checkValidRef(ctx, shortRef); ok && err == nil {
 		return shortRef
 	}
 	return ref
@@ -83,8 +84,8 @@ func shortenRef(ref string) string {
 
 // checkoutRef switches branches to the specified ref. If a post-checkout
 // command is provided, it is run after checking out the ref.
-func checkoutRef(ref string, postCheckout string) error {
-	if err := spawn("git", "checkout", "-q", ref); err != nil {
+func checkoutRef(ctx context.Context, ref string, postCheckout string) error {
+	if err := spawn(ctx, "git", "checkout", "-q", ref); err != nil {
 		return errors.Wrap(err, "checkout ref")
 	}
 	if postCheckout == "" {
@@ -92,10 +93,17 @@ func checkoutRef(ref string, postCheckout string) error {
 	}
 	args := strings.Split(postCheckout, " ")
 	// Send all output of post-checkout hook to stderr.
-	err := spawnWith(os.Stdin, os.Stderr, os.Stderr, args...)
+	err := spawnWith(ctx, os.Stdin, os.Stderr, os.Stderr, args...)
 	return errors.Wrap(err, "post-checkout")
 }
 
-func subjectForRef(ref string) (string, error) {
-	return capture("git", "log", "--format=%s", "-1", ref)
+func subjectForRef(ctx context.Context, ref string) (string, error) {
+	return capture(ctx, "git", "log", "--format=%s", "-1", ref)
+}
+
+// isAncestor determines whether the first git ref is an ancestor of the second
+// git ref.
+func isAncestor(ctx context.Context, ancestor, ref string) bool {
+	_, err := capture(ctx, "git", "merge-base", "--is-ancestor", ancestor, ref)
+	return err == nil
 }
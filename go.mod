@@ -1,11 +1,34 @@
 module github.com/nvanbenschoten/benchdiff
 
-go 1.13
+go 1.20
 
 require (
 	github.com/google/pprof v0.0.0-20210720184732-4bb14d4b1be1
 	github.com/pkg/errors v0.9.1
 	github.com/spf13/pflag v1.0.5
+	go.etcd.io/bbolt v1.3.6
 	golang.org/x/perf v0.0.0-20250106172127-400946f43c82
 	google.golang.org/api v0.126.0
 )
+
+require (
+	cloud.google.com/go/compute/metadata v0.3.0 // indirect
+	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/google/s2a-go v0.1.4 // indirect
+	github.com/google/safehtml v0.0.2 // indirect
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/googleapis/enterprise-certificate-proxy v0.2.3 // indirect
+	github.com/googleapis/gax-go/v2 v2.11.0 // indirect
+	github.com/ianlancetaylor/demangle v0.0.0-20200824232613-28f6c0f3b639 // indirect
+	go.opencensus.io v0.24.0 // indirect
+	golang.org/x/crypto v0.32.0 // indirect
+	golang.org/x/net v0.34.0 // indirect
+	golang.org/x/oauth2 v0.25.0 // indirect
+	golang.org/x/sys v0.29.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+	google.golang.org/appengine v1.6.7 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20230530153820-e85fd2cbaebc // indirect
+	google.golang.org/grpc v1.55.0 // indirect
+	google.golang.org/protobuf v1.31.0 // indirect
+)
@@ -0,0 +1,158 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+// fakeServer is an in-memory implementation of the subset of the Google Drive
+// and Google Sheets APIs that Service uses. Both APIs are served from the same
+// endpoint. The Drive client resolves its paths relative to the endpoint
+// (e.g. "about", "files/{id}/permissions") while the Sheets client prefixes
+// its paths with "v4/", so the two never collide.
+type fakeServer struct {
+	*httptest.Server
+
+	mu struct {
+		sync.Mutex
+		nextID int
+		sheets map[string]*sheets.Spreadsheet
+		perms  map[string][]*drive.Permission
+		// failures maps a request path prefix to an HTTP status code that
+		// the server should respond with instead of handling the request.
+		// A status code of 0 causes the server to drop the connection.
+		failures map[string]int
+	}
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	f := &fakeServer{}
+	f.mu.sheets = make(map[string]*sheets.Spreadsheet)
+	f.mu.perms = make(map[string][]*drive.Permission)
+	f.mu.failures = make(map[string]int)
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// newService constructs a Service that talks to the fake server.
+func (f *fakeServer) newService(ctx context.Context) (*Service, error) {
+	return New(ctx,
+		option.WithEndpoint(f.URL+"/"),
+		option.WithHTTPClient(f.Client()),
+	)
+}
+
+// failOn instructs the server to respond to all requests whose path starts
+// with the provided prefix with the specified status code.
+func (f *fakeServer) failOn(pathPrefix string, code int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mu.failures[pathPrefix] = code
+}
+
+func (f *fakeServer) spreadsheet(id string) *sheets.Spreadsheet {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.mu.sheets[id]
+}
+
+func (f *fakeServer) permissions(id string) []*drive.Permission {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.mu.perms[id]
+}
+
+func (f *fakeServer) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	for prefix, code := range f.mu.failures {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		if code == 0 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				_ = conn.Close()
+			}
+			return
+		}
+		writeError(w, code, "injected failure")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && path == "about":
+		writeJSON(w, &drive.About{User: &drive.User{DisplayName: "fake"}})
+
+	case r.Method == http.MethodPost && path == "v4/spreadsheets":
+		var s sheets.Spreadsheet
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		f.mu.nextID++
+		s.SpreadsheetId = fmt.Sprintf("sheet-%d", f.mu.nextID)
+		s.SpreadsheetUrl = fmt.Sprintf("%s/spreadsheets/d/%s", f.URL, s.SpreadsheetId)
+		f.mu.sheets[s.SpreadsheetId] = &s
+		writeJSON(w, &s)
+
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "v4/spreadsheets/"):
+		id := strings.TrimPrefix(path, "v4/spreadsheets/")
+		s, ok := f.mu.sheets[id]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Requested entity was not found.")
+			return
+		}
+		writeJSON(w, s)
+
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "files/") &&
+		strings.HasSuffix(path, "/permissions"):
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "files/"), "/permissions")
+		if _, ok := f.mu.sheets[id]; !ok {
+			writeError(w, http.StatusNotFound, "File not found: "+id)
+			return
+		}
+		var p drive.Permission
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		p.Id = fmt.Sprintf("perm-%d", len(f.mu.perms[id])+1)
+		f.mu.perms[id] = append(f.mu.perms[id], &p)
+		writeJSON(w, &p)
+
+	default:
+		writeError(w, http.StatusNotFound, "unknown request: "+r.Method+" "+path)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error response in the format that googleapi.CheckResponse
+// decodes into a *googleapi.Error.
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": msg,
+		},
+	})
+}
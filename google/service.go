@@ -28,13 +28,17 @@ type Service struct {
 
 // New creates a new Service. It verifies that credentials are properly set and
 // returns an error if they are not.
-func New(ctx context.Context) (*Service, error) {
+//
+// The provided client options are passed to both the Drive and the Sheets
+// clients. They can be used to point the Service at a different endpoint or
+// to supply a custom HTTP client, which is primarily useful for testing.
+func New(ctx context.Context, opts ...option.ClientOption) (*Service, error) {
 	var srv Service
 	var err error
-	if srv.drive, err = newDriveService(ctx); err != nil {
+	if srv.drive, err = newDriveService(ctx, opts...); err != nil {
 		return nil, errors.Wrap(err, "retrieve Drive client")
 	}
-	if srv.sheets, err = newSheetsService(ctx); err != nil {
+	if srv.sheets, err = newSheetsService(ctx, opts...); err != nil {
 		return nil, errors.Wrap(err, "retrieve Sheets client")
 	}
 	if err = srv.testServices(ctx); err != nil {
@@ -44,13 +48,15 @@ func New(ctx context.Context) (*Service, error) {
 }
 
 // newDriveService constructs a new Google Drive service.
-func newDriveService(ctx context.Context) (*drive.Service, error) {
-	return drive.NewService(ctx, option.WithScopes(drive.DriveFileScope))
+func newDriveService(ctx context.Context, opts ...option.ClientOption) (*drive.Service, error) {
+	opts = append([]option.ClientOption{option.WithScopes(drive.DriveFileScope)}, opts...)
+	return drive.NewService(ctx, opts...)
 }
 
 // newSheetsService constructs a new Google Sheets service.
-func newSheetsService(ctx context.Context) (*sheets.Service, error) {
-	return sheets.NewService(ctx, option.WithScopes(sheets.SpreadsheetsScope))
+func newSheetsService(ctx context.Context, opts ...option.ClientOption) (*sheets.Service, error) {
+	opts = append([]option.ClientOption{option.WithScopes(sheets.SpreadsheetsScope)}, opts...)
+	return sheets.NewService(ctx, opts...)
 }
 
 func (srv *Service) testServices(ctx context.Context) error {
@@ -58,8 +64,9 @@ func (srv *Service) testServices(ctx context.Context) error {
 		return errors.Wrap(err, "testing Drive client")
 	}
 	if _, err := srv.sheets.Spreadsheets.Get("none").Context(ctx).Do(); err != nil {
-		// We expect a 404.
-		if err.(*googleapi.Error).Code != http.StatusNotFound {
+		// We expect a 404. Anything else, including errors that did not come
+		// from the API itself (e.g. connection failures), is a real problem.
+		if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != http.StatusNotFound {
 			return errors.Wrap(err, "testing Sheets client")
 		}
 	}
@@ -67,8 +74,10 @@ func (srv *Service) testServices(ctx context.Context) error {
 }
 
 // CreateSheet creates a new Google spreadsheet with the provided metric data.
+// If info is non-empty, its name-value pairs, like the machine that the
+// benchmarks ran on, are added as an info sheet.
 func (srv *Service) CreateSheet(
-	ctx context.Context, name string, tables []*benchstat.Table,
+	ctx context.Context, name string, tables []*benchstat.Table, info [][2]string,
 ) (string, error) {
 	var s sheets.Spreadsheet
 	s.Properties = &sheets.SpreadsheetProperties{Title: name}
@@ -85,6 +94,11 @@ func (srv *Service) CreateSheet(
 	overview := srv.createOverviewSheet(sheetInfos)
 	s.Sheets = append([]*sheets.Sheet{overview}, s.Sheets...)
 
+	// Info sheet. Place at the end.
+	if len(info) > 0 {
+		s.Sheets = append(s.Sheets, srv.createInfoSheet(info, len(tables)+1))
+	}
+
 	// Create the spreadsheet.
 	res, err := srv.createSheet(ctx, s)
 	if err != nil {
@@ -168,7 +182,13 @@ func (srv *Service) createRawSheet(t *benchstat.Table, tIdx int) (*sheets.Sheet,
 	// Data rows.
 	for _, row := range t.Rows {
 		var vals []*sheets.CellData
-		vals = append(vals, strCell(row.Benchmark))
+		name := row.Benchmark
+		if pkg := strings.TrimPrefix(row.Group, "pkg:"); pkg != row.Group {
+			// Benchmarks grouped by package are prefixed by it, as sheets
+			// have no group headers.
+			name = pkg + "." + name
+		}
+		vals = append(vals, strCell(name))
 		for _, val := range row.Metrics {
 			vals = append(vals, numCell(val.Mean))
 		}
@@ -206,7 +226,7 @@ func (srv *Service) createRawSheet(t *benchstat.Table, tIdx int) (*sheets.Sheet,
 	return sheet, info
 }
 
-// createRawSheet creates a new sheet that contains an overview of all raw
+// createOverviewSheet creates a new sheet that contains an overview of all raw
 // metric data using pivot tables. The sheet is formatted like:
 //
 //  +------------+---------+----+------------+----------+
@@ -304,6 +324,38 @@ func (srv *Service) createOverviewSheet(rawInfos []rawSheetInfo) *sheets.Sheet {
 	return pivot
 }
 
+// createInfoSheet creates a new sheet that lists name-value pairs. The sheet
+// is formatted like:
+//
+//  +--------+----------------------+
+//  | go     | go1.21.4 linux/amd64 |
+//  | cores  | 8                    |
+//  +--------+----------------------+
+//
+func (srv *Service) createInfoSheet(info [][2]string, idx int) *sheets.Sheet {
+	props := &sheets.SheetProperties{
+		Title:   "Info",
+		SheetId: sheetIDForTable(idx),
+		GridProperties: &sheets.GridProperties{
+			ColumnCount: 2,
+			RowCount:    int64(len(info)),
+		},
+	}
+	var data []*sheets.RowData
+	for _, kv := range info {
+		data = append(data, &sheets.RowData{
+			Values: []*sheets.CellData{strCell(kv[0]), strCell(kv[1])},
+		})
+	}
+	return &sheets.Sheet{
+		Properties: props,
+		Data: []*sheets.GridData{{
+			RowData:        data,
+			ColumnMetadata: []*sheets.DimensionProperties{withSize(150), withSize(400)},
+		}},
+	}
+}
+
 func (srv *Service) createSheet(ctx context.Context, s sheets.Spreadsheet) (*sheets.Spreadsheet, error) {
 	res, err := srv.sheets.Spreadsheets.Create(&s).Context(ctx).Do()
 	if err != nil {
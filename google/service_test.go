@@ -0,0 +1,252 @@
+package google
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/perf/benchstat"
+)
+
+func testTables() []*benchstat.Table {
+	return []*benchstat.Table{
+		{
+			Metric:      "time/op",
+			OldNewDelta: true,
+			Configs:     []string{"old", "new"},
+			Rows: []*benchstat.Row{
+				{
+					Benchmark: "Benchmark1",
+					Metrics: []*benchstat.Metrics{
+						{Unit: "ns/op", Mean: 290026.2},
+						{Unit: "ns/op", Mean: 190575},
+					},
+					Delta: "-34.29%",
+					Note:  "(p=0.008 n=5+5)",
+				},
+				{
+					Benchmark: "Benchmark2",
+					Metrics: []*benchstat.Metrics{
+						{Unit: "ns/op", Mean: 15588},
+						{Unit: "ns/op", Mean: 15717.6},
+					},
+					Delta: "~",
+					Note:  "(p=0.841 n=5+5)",
+				},
+			},
+		},
+		{
+			Metric:      "alloc/op",
+			OldNewDelta: true,
+			Configs:     []string{"old", "new"},
+			Rows: []*benchstat.Row{
+				{
+					Benchmark: "Benchmark1",
+					Metrics: []*benchstat.Metrics{
+						{Unit: "B/op", Mean: 128},
+						{Unit: "B/op", Mean: 128},
+					},
+					Delta: "~",
+					Note:  "(all equal)",
+				},
+			},
+		},
+	}
+}
+
+func TestNew(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("ok", func(t *testing.T) {
+		f := newFakeServer(t)
+		if _, err := f.newService(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	testCases := []struct {
+		name   string
+		prefix string
+		code   int
+		expErr string
+	}{
+		{"drive unauthorized", "about", http.StatusUnauthorized, "testing Drive client"},
+		{"sheets forbidden", "v4/spreadsheets/", http.StatusForbidden, "testing Sheets client"},
+		// Errors that are not *googleapi.Error must not cause a panic.
+		{"sheets connection dropped", "v4/spreadsheets/", 0, "testing Sheets client"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakeServer(t)
+			f.failOn(tc.prefix, tc.code)
+			_, err := f.newService(ctx)
+			if err == nil {
+				t.Fatal("expected error, found none")
+			}
+			if !strings.Contains(err.Error(), tc.expErr) {
+				t.Fatalf("expected error containing %q, found %v", tc.expErr, err)
+			}
+		})
+	}
+}
+
+func TestCreateSheet(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeServer(t)
+	srv, err := f.newService(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url, err := srv.CreateSheet(ctx, "benchdiff: ./pkg/... (abc -> def)", testTables(),
+		[][2]string{{"go", "go1.21.4 linux/amd64"}, {"cores", "8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const id = "sheet-1"
+	if !strings.HasSuffix(url, "/spreadsheets/d/"+id) {
+		t.Fatalf("unexpected spreadsheet url %q", url)
+	}
+
+	s := f.spreadsheet(id)
+	if s == nil {
+		t.Fatalf("spreadsheet %q not created", id)
+	}
+	if s.Properties.Title != "benchdiff: ./pkg/... (abc -> def)" {
+		t.Errorf("unexpected title %q", s.Properties.Title)
+	}
+	var titles []string
+	for _, sh := range s.Sheets {
+		titles = append(titles, sh.Properties.Title)
+	}
+	expTitles := []string{"Overview: Significant Changes", "Raw: time/op", "Raw: alloc/op", "Info"}
+	if strings.Join(titles, ",") != strings.Join(expTitles, ",") {
+		t.Errorf("expected sheets %q, found %q", expTitles, titles)
+	}
+
+	// The spreadsheet should be opened up to anyone with the link.
+	perms := f.permissions(id)
+	if len(perms) != 1 {
+		t.Fatalf("expected 1 permission, found %d", len(perms))
+	}
+	if perms[0].Type != "anyone" || perms[0].Role != "writer" {
+		t.Errorf("unexpected permission %+v", perms[0])
+	}
+}
+
+func TestCreateSheetErrors(t *testing.T) {
+	ctx := context.Background()
+	testCases := []struct {
+		name   string
+		prefix string
+		expErr string
+	}{
+		{"create", "v4/spreadsheets", "create new Spreadsheet"},
+		{"permissions", "files/", "update Spreadsheet permissions"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakeServer(t)
+			srv, err := f.newService(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.failOn(tc.prefix, http.StatusInternalServerError)
+			_, err = srv.CreateSheet(ctx, "name", testTables(), nil /* info */)
+			if err == nil {
+				t.Fatal("expected error, found none")
+			}
+			if !strings.Contains(err.Error(), tc.expErr) {
+				t.Fatalf("expected error containing %q, found %v", tc.expErr, err)
+			}
+		})
+	}
+}
+
+func TestCreateRawSheet(t *testing.T) {
+	var srv Service
+	table := testTables()[0]
+	sh, info := srv.createRawSheet(table, 0)
+
+	if info.id != 1 || sh.Properties.SheetId != 1 {
+		t.Errorf("expected sheet id 1, found %d", info.id)
+	}
+	if info.deltaCol != 3 {
+		t.Errorf("expected delta column 3, found %d", info.deltaCol)
+	}
+	if info.grid.RowCount != 3 || info.grid.ColumnCount != 5 {
+		t.Errorf("unexpected grid dimensions %dx%d", info.grid.RowCount, info.grid.ColumnCount)
+	}
+	if exp := []string{"-34.29%"}; strings.Join(info.nonZeroVals, ",") != strings.Join(exp, ",") {
+		t.Errorf("expected non-zero values %q, found %q", exp, info.nonZeroVals)
+	}
+
+	rows := sh.Data[0].RowData
+	header := rows[0].Values
+	if v := *header[1].UserEnteredValue.StringValue; v != "old time/op (ns/op)" {
+		t.Errorf("unexpected header %q", v)
+	}
+	first := rows[1].Values
+	if v := *first[1].UserEnteredValue.NumberValue; v != 290026.2 {
+		t.Errorf("unexpected old value %f", v)
+	}
+	if v := *first[3].UserEnteredValue.NumberValue; v != -0.3429 {
+		t.Errorf("unexpected delta %f", v)
+	}
+	if first[3].UserEnteredFormat.NumberFormat.Type != "PERCENT" {
+		t.Errorf("expected delta to be formatted as a percentage")
+	}
+	second := rows[2].Values
+	if v := *second[3].UserEnteredValue.StringValue; v != "~" {
+		t.Errorf("unexpected delta %q", v)
+	}
+}
+
+func TestCreateOverviewSheet(t *testing.T) {
+	var srv Service
+	var infos []rawSheetInfo
+	for i, table := range testTables() {
+		_, info := srv.createRawSheet(table, i)
+		infos = append(infos, info)
+	}
+	sh := srv.createOverviewSheet(infos)
+
+	if sh.Properties.SheetId != 3 {
+		t.Errorf("expected sheet id 3, found %d", sh.Properties.SheetId)
+	}
+	vals := sh.Data[0].RowData[0].Values
+	// Pivot table and its delta column, a spacer, then a "no change" cell.
+	if len(vals) != 4 {
+		t.Fatalf("expected 4 cells, found %d", len(vals))
+	}
+	pivot := vals[0].PivotTable
+	if pivot == nil {
+		t.Fatal("expected pivot table in first cell")
+	}
+	if pivot.Source.SheetId != 1 {
+		t.Errorf("expected pivot table source sheet 1, found %d", pivot.Source.SheetId)
+	}
+	if order := pivot.Rows[0].SortOrder; order != "ASCENDING" {
+		t.Errorf("expected ascending sort order, found %s", order)
+	}
+	if v := *vals[3].UserEnteredValue.StringValue; v != "no change in alloc/op" {
+		t.Errorf("unexpected cell %q", v)
+	}
+	if len(sh.ConditionalFormats) != 1 {
+		t.Errorf("expected 1 conditional format, found %d", len(sh.ConditionalFormats))
+	}
+}
+
+func TestDeltaToPercentString(t *testing.T) {
+	for in, exp := range map[string]string{
+		"+4.00%":  "4%",
+		"-34.29%": "-34.29%",
+		"+1.50%":  "1.5%",
+		"-10.00%": "-10%",
+	} {
+		if out := deltaToPercentString(in); out != exp {
+			t.Errorf("deltaToPercentString(%q) = %q, expected %q", in, out, exp)
+		}
+	}
+}
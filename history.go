@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/perf/benchstat"
+)
+
+const historyUsage = `usage: benchdiff history [--metric <metric>] <benchmark>`
+
+const historyHelpString = `benchdiff history shows how the metrics of the benchmarks matching the
+provided regexp evolved across all commits recorded in the local results
+database. Every benchdiff run records its results in this database.
+
+Options:
+  -m, --metric    <metric>  show only this metric (e.g. 'time/op', 'alloc/op')
+      --help                display this help`
+
+// historyEntry is a single measurement of a benchmark's metric at a commit.
+type historyEntry struct {
+	time    time.Time // of the run that measured the commit
+	ref     string
+	subject string
+	metrics metricsRecord
+}
+
+// historyKey identifies the series of measurements of one of a benchmark's
+// metrics. Benchmarks of runs with --by-pkg are grouped by their package, so
+// same-named benchmarks in different packages form separate series.
+type historyKey struct {
+	group, benchmark, metric string
+}
+
+func runHistory(ctx context.Context, args []string) error {
+	var help bool
+	var metric string
+	flags := pflag.NewFlagSet("history", pflag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, historyUsage) }
+	flags.BoolVarP(&help, "help", "h", false, "")
+	flags.StringVarP(&metric, "metric", "m", "", "")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if help || flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, historyUsage)
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, historyHelpString)
+		return nil
+	}
+
+	// Benchmark names are stored without their "Benchmark" prefix.
+	re, err := regexp.Compile(strings.TrimPrefix(flags.Arg(0), "Benchmark"))
+	if err != nil {
+		return err
+	}
+
+	db, err := openResultsDB(benchdiffDir)
+	if err != nil {
+		return err
+	}
+	defer db.close()
+
+	hist, err := collectHistory(db, re, metric)
+	if err != nil {
+		return err
+	}
+	if len(hist) == 0 {
+		return fmt.Errorf("no recorded runs of benchmarks matching %q", flags.Arg(0))
+	}
+	printHistory(os.Stdout, hist)
+	return nil
+}
+
+// collectHistory gathers the measurements of all benchmarks matching re from
+// the results database. If metric is not empty, only that metric is gathered.
+// Commits are listed in the order in which they were first measured. If a
+// commit was measured by multiple runs, its most recent measurement is used.
+func collectHistory(
+	db *resultsDB, re *regexp.Regexp, metric string,
+) (map[historyKey][]historyEntry, error) {
+	hist := make(map[historyKey][]historyEntry)
+	add := func(key historyKey, e historyEntry) {
+		for i := range hist[key] {
+			if hist[key][i].ref == e.ref {
+				hist[key][i] = e
+				return
+			}
+		}
+		hist[key] = append(hist[key], e)
+	}
+	err := db.forEachRun(func(rec runRecord) error {
+		for _, t := range rec.Tables {
+			if metric != "" && t.Metric != metric {
+				continue
+			}
+			for _, row := range t.Rows {
+				if !re.MatchString(row.Benchmark) {
+					continue
+				}
+				key := historyKey{group: row.Group, benchmark: row.Benchmark, metric: t.Metric}
+				if row.Old != nil {
+					add(key, historyEntry{rec.Time, rec.Old.Ref, rec.Old.Subject, *row.Old})
+				}
+				if row.New != nil {
+					add(key, historyEntry{rec.Time, rec.New.Ref, rec.New.Subject, *row.New})
+				}
+			}
+		}
+		return nil
+	})
+	return hist, err
+}
+
+// printHistory prints each series of measurements as a table, like:
+//
+//	String-8 time/op
+//	  run                        ref      value          delta   subject
+//	  2021-07-20T18_47_32-04:00  4bb14d4  68.6ns ± 0%            Add new thing
+//	  2021-07-21T09_12_03-04:00  d1fbdb2  70.2ns ± 1%    +2.33%  Speed up thing
+func printHistory(w io.Writer, hist map[historyKey][]historyEntry) {
+	keys := make([]historyKey, 0, len(hist))
+	for k := range hist {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].group != keys[j].group {
+			return keys[i].group < keys[j].group
+		}
+		if keys[i].benchmark != keys[j].benchmark {
+			return keys[i].benchmark < keys[j].benchmark
+		}
+		return keys[i].metric < keys[j].metric
+	})
+
+	for i, k := range keys {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		if k.group != "" {
+			fmt.Fprintf(w, "%s ", k.group)
+		}
+		fmt.Fprintf(w, "%s %s\n", k.benchmark, k.metric)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  run\tref\tvalue\tdelta\tsubject")
+		var prev *historyEntry
+		for j := range hist[k] {
+			e := &hist[k][j]
+			m := benchstat.Metrics{
+				Unit: e.metrics.Unit,
+				Min:  e.metrics.Min,
+				Mean: e.metrics.Mean,
+				Max:  e.metrics.Max,
+			}
+			val := m.Format(benchstat.NewScaler(m.Mean, m.Unit))
+			var delta string
+			if prev != nil && prev.metrics.Mean != 0 {
+				pct := (e.metrics.Mean/prev.metrics.Mean - 1) * 100
+				delta = fmt.Sprintf("%+.2f%%", pct)
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%.50s\n",
+				e.time.Format(timeFormat), e.ref, val, delta, e.subject)
+			prev = e
+		}
+		_ = tw.Flush()
+	}
+}
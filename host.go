@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// hostInfo describes the machine and toolchain that a run was performed with,
+// so that results from different machines are not compared by accident.
+type hostInfo struct {
+	Hostname     string `json:"hostname,omitempty"`
+	GoVersion    string `json:"go_version"` // like "go1.21.4 linux/amd64"
+	GOARCH       string `json:"goarch"`
+	GOAMD64      string `json:"goamd64,omitempty"`
+	GOEXPERIMENT string `json:"goexperiment,omitempty"`
+	CPUModel     string `json:"cpu_model,omitempty"`
+	Cores        int    `json:"cores"`
+	Memory       uint64 `json:"memory,omitempty"` // in bytes
+	Kernel       string `json:"kernel,omitempty"`
+	// BenchConfig holds the configuration lines, like "goos: linux" and
+	// "cpu: ...", that the test binaries of each ref printed, keyed by ref.
+	BenchConfig map[string][]string `json:"bench_config,omitempty"`
+}
+
+// gatherHostInfo determines the host and toolchain metadata. Anything that
+// cannot be determined is left empty.
+func gatherHostInfo(ctx context.Context) *hostInfo {
+	h := &hostInfo{Cores: runtime.NumCPU()}
+	h.Hostname, _ = os.Hostname()
+	if out, err := capture(ctx, "go", "version"); err == nil {
+		h.GoVersion = strings.TrimPrefix(out, "go version ")
+	}
+	if out, err := capture(ctx, "go", "env", "-json", "GOARCH", "GOAMD64", "GOEXPERIMENT"); err == nil {
+		var env map[string]string
+		if json.Unmarshal([]byte(out), &env) == nil {
+			h.GOARCH, h.GOAMD64, h.GOEXPERIMENT = env["GOARCH"], env["GOAMD64"], env["GOEXPERIMENT"]
+		}
+	}
+	if h.GOARCH == "" {
+		h.GOARCH = runtime.GOARCH
+	}
+	if h.GOARCH != "amd64" {
+		h.GOAMD64 = ""
+	}
+	if f, err := os.Open("/proc/cpuinfo"); err == nil {
+		h.CPUModel = procInfoField(f, "model name")
+		_ = f.Close()
+	} else if out, err := capture(ctx, "sysctl", "-n", "machdep.cpu.brand_string"); err == nil {
+		h.CPUModel = out
+	}
+	if f, err := os.Open("/proc/meminfo"); err == nil {
+		// Like "MemTotal:       16318412 kB".
+		kb, err := strconv.ParseUint(strings.TrimSuffix(procInfoField(f, "MemTotal"), " kB"), 10, 64)
+		if err == nil {
+			h.Memory = kb << 10
+		}
+		_ = f.Close()
+	}
+	if out, err := capture(ctx, "uname", "-sr"); err == nil {
+		h.Kernel = out
+	}
+	return h
+}
+
+// procInfoField returns the value of the first field with the provided name
+// in a /proc file of "name: value" lines, like /proc/cpuinfo.
+func procInfoField(r io.Reader, name string) string {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		k, v, ok := strings.Cut(s.Text(), ":")
+		if ok && strings.TrimSpace(k) == name {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// benchConfigKeys are the configuration lines printed by test binaries that
+// are recorded in hostInfo.BenchConfig.
+var benchConfigKeys = []string{"goos", "goarch", "pkg", "cpu"}
+
+// parseBenchConfig returns the distinct configuration lines, like
+// "goarch: amd64", in the benchmark output, in order of appearance.
+func parseBenchConfig(r io.Reader) ([]string, error) {
+	var lines []string
+	seen := make(map[string]struct{})
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		k, _, ok := strings.Cut(line, ": ")
+		if !ok || !isBenchConfigKey(k) {
+			continue
+		}
+		if _, ok := seen[line]; !ok {
+			seen[line] = struct{}{}
+			lines = append(lines, line)
+		}
+	}
+	return lines, s.Err()
+}
+
+func isBenchConfigKey(k string) bool {
+	for _, c := range benchConfigKeys {
+		if k == c {
+			return true
+		}
+	}
+	return false
+}
+
+// recordBenchConfig records the configuration lines printed by the test
+// binaries of the suite.
+func (h *hostInfo) recordBenchConfig(bs *benchSuite) error {
+	if _, err := bs.outFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	lines, err := parseBenchConfig(bs.outFile)
+	if err != nil {
+		return err
+	}
+	if h.BenchConfig == nil {
+		h.BenchConfig = make(map[string][]string)
+	}
+	h.BenchConfig[bs.ref] = lines
+	return nil
+}
+
+// machineConfig returns the values of the configuration lines of the ref that
+// describe the machine, joined by key. The package lines are omitted.
+func (h *hostInfo) machineConfig(ref string) map[string]string {
+	vals := make(map[string][]string)
+	for _, line := range h.BenchConfig[ref] {
+		k, v, _ := strings.Cut(line, ": ")
+		if k != "pkg" {
+			vals[k] = append(vals[k], v)
+		}
+	}
+	m := make(map[string]string, len(vals))
+	for k, vs := range vals {
+		m[k] = strings.Join(vs, ", ")
+	}
+	return m
+}
+
+// summary describes the host in a line, like "Intel(R) Xeon(R) CPU @ 2.20GHz,
+// 8 cores, 31.3 GiB, Linux 6.1.0, go1.21.4 linux/amd64".
+func (h *hostInfo) summary() string {
+	var parts []string
+	if h.CPUModel != "" {
+		parts = append(parts, h.CPUModel)
+	}
+	parts = append(parts, fmt.Sprintf("%d cores", h.Cores))
+	if h.Memory != 0 {
+		parts = append(parts, formatMemory(h.Memory))
+	}
+	if h.Kernel != "" {
+		parts = append(parts, h.Kernel)
+	}
+	if h.GoVersion != "" {
+		parts = append(parts, h.GoVersion)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatMemory(b uint64) string {
+	return fmt.Sprintf("%.1f GiB", float64(b)/(1<<30))
+}
+
+// hostField is a named piece of host metadata, as output in reports.
+type hostField struct {
+	name, value string
+}
+
+// fields returns the host metadata to output in reports. The test binary
+// configuration of the old and new refs is output once if it is the same for
+// both.
+func (h *hostInfo) fields(oldRef, newRef string) []hostField {
+	fs := []hostField{{"go", h.GoVersion}, {"GOARCH", h.GOARCH}}
+	if h.GOAMD64 != "" {
+		fs = append(fs, hostField{"GOAMD64", h.GOAMD64})
+	}
+	if h.GOEXPERIMENT != "" {
+		fs = append(fs, hostField{"GOEXPERIMENT", h.GOEXPERIMENT})
+	}
+	fs = append(fs,
+		hostField{"cpu model", h.CPUModel},
+		hostField{"cores", strconv.Itoa(h.Cores)},
+	)
+	if h.Memory != 0 {
+		fs = append(fs, hostField{"memory", formatMemory(h.Memory)})
+	}
+	if h.Kernel != "" {
+		fs = append(fs, hostField{"kernel", h.Kernel})
+	}
+
+	oldCfg, newCfg := h.machineConfig(oldRef), h.machineConfig(newRef)
+	var keys []string
+	for k := range oldCfg {
+		keys = append(keys, k)
+	}
+	for k := range newCfg {
+		if _, ok := oldCfg[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var mismatched []string
+	for _, k := range keys {
+		if oldCfg[k] == newCfg[k] {
+			fs = append(fs, hostField{k, oldCfg[k]})
+		} else {
+			fs = append(fs, hostField{k + " (old)", oldCfg[k]}, hostField{k + " (new)", newCfg[k]})
+			mismatched = append(mismatched, k)
+		}
+	}
+	if len(mismatched) > 0 {
+		fs = append(fs, hostField{"warning", fmt.Sprintf(
+			"the old and new test binaries ran with different %s", strings.Join(mismatched, ", "))})
+	}
+	return fs
+}
+
+// sameMachine returns whether the two hosts are the same kind of machine with
+// the same toolchain, such that their results are comparable.
+func (h *hostInfo) sameMachine(o *hostInfo) bool {
+	return h.GoVersion == o.GoVersion && h.GOARCH == o.GOARCH && h.GOAMD64 == o.GOAMD64 &&
+		h.GOEXPERIMENT == o.GOEXPERIMENT && h.CPUModel == o.CPUModel && h.Cores == o.Cores
+}
+
+// formatHostText writes the host metadata of the run.
+func formatHostText(w io.Writer, md runMetadata) {
+	if md.Host == nil {
+		return
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, f := range md.Host.fields(md.OldRef, md.NewRef) {
+		fmt.Fprintf(tw, "%s:\t%s\n", f.name, f.value)
+	}
+	_ = tw.Flush()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBenchConfig(t *testing.T) {
+	const out = `goos: linux
+goarch: amd64
+pkg: example.com/a
+cpu: Intel(R) Xeon(R) CPU @ 2.20GHz
+BenchmarkFoo-8   	1000000	      1024 ns/op
+PASS
+goos: linux
+goarch: amd64
+pkg: example.com/b
+cpu: Intel(R) Xeon(R) CPU @ 2.20GHz
+BenchmarkBar-8   	1000000	      2048 ns/op
+    bar_test.go:12: cpu: not a config line
+PASS
+`
+	lines, err := parseBenchConfig(strings.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{
+		"goos: linux",
+		"goarch: amd64",
+		"pkg: example.com/a",
+		"cpu: Intel(R) Xeon(R) CPU @ 2.20GHz",
+		"pkg: example.com/b",
+	}
+	if !reflect.DeepEqual(lines, exp) {
+		t.Errorf("expected %q, found %q", exp, lines)
+	}
+}
+
+func TestHostInfoFields(t *testing.T) {
+	h := &hostInfo{
+		GoVersion: "go1.21.4 linux/amd64",
+		GOARCH:    "amd64",
+		GOAMD64:   "v1",
+		CPUModel:  "Xeon",
+		Cores:     8,
+		BenchConfig: map[string][]string{
+			"abc": {"goos: linux", "pkg: example.com/a", "cpu: Xeon"},
+			"def": {"goos: linux", "pkg: example.com/a", "cpu: EPYC"},
+		},
+	}
+	exp := []hostField{
+		{"go", "go1.21.4 linux/amd64"},
+		{"GOARCH", "amd64"},
+		{"GOAMD64", "v1"},
+		{"cpu model", "Xeon"},
+		{"cores", "8"},
+		{"cpu (old)", "Xeon"},
+		{"cpu (new)", "EPYC"},
+		{"goos", "linux"},
+		{"warning", "the old and new test binaries ran with different cpu"},
+	}
+	if fs := h.fields("abc", "def"); !reflect.DeepEqual(fs, exp) {
+		t.Errorf("expected %q, found %q", exp, fs)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Interleave modes, which determine the granularity at which the runs of the
+// old and new refs alternate.
+const (
+	// interleavePackage runs all benchmarks in a package on one ref, then on
+	// the other, in each iteration.
+	interleavePackage = "package"
+	// interleaveBenchmark runs each benchmark on one ref, then on the other,
+	// in each iteration, in a random order.
+	interleaveBenchmark = "benchmark"
+)
+
+func checkInterleave(mode string) error {
+	switch mode {
+	case interleavePackage, interleaveBenchmark:
+		return nil
+	default:
+		return errors.Errorf("unknown interleave mode %q; expected %q or %q",
+			mode, interleavePackage, interleaveBenchmark)
+	}
+}
+
+// benchUnit is a unit of work that is run on both refs in turn.
+type benchUnit struct {
+	// name is the name of the benchmark, or empty if the unit runs all of the
+	// package's benchmarks.
+	name string
+	// pattern is passed to -test.bench.
+	pattern string
+	// suites are the benchmark suites that the unit runs on. A benchmark that
+	// was added or removed between the refs only runs on one of them.
+	suites []*benchSuite
+	// profile is set if the unit is a benchmark selected by --profile-run,
+	// whose profiles are recorded separately from all others.
+	profile bool
+}
+
+// runsOn returns whether the unit runs on the benchmark suite.
+func (u benchUnit) runsOn(bs *benchSuite) bool {
+	for _, s := range u.suites {
+		if s == bs {
+			return true
+		}
+	}
+	return false
+}
+
+// listBenchUnits determines the units of work for the test binary. In package
+// mode, there is a single unit for all of the binary's benchmarks. In
+// benchmark mode, there is one unit per top-level benchmark matching the run
+// pattern, sorted by name. If profileRun is non-nil, the benchmarks that it
+// matches are profiled, each in a unit of its own, in either mode.
+func listBenchUnits(
+	ctx context.Context,
+	mode string,
+	bs1, bs2 *benchSuite,
+	test, runPattern string,
+	profileRun *regexp.Regexp,
+) ([]benchUnit, error) {
+	if mode == interleavePackage && profileRun == nil {
+		return []benchUnit{{pattern: runPattern, suites: []*benchSuite{bs1, bs2}}}, nil
+	}
+
+	top, sub := splitBenchPattern(runPattern)
+	byName := make(map[string]*benchUnit)
+	var names []string
+	for _, bs := range []*benchSuite{bs1, bs2} {
+		benches, err := listBenchmarks(ctx, bs, test, top)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range benches {
+			u, ok := byName[b]
+			if !ok {
+				pattern := "^" + regexp.QuoteMeta(b) + "$"
+				if sub != "" {
+					pattern += "/" + sub
+				}
+				u = &benchUnit{name: b, pattern: pattern}
+				byName[b] = u
+				names = append(names, b)
+			}
+			u.suites = append(u.suites, bs)
+		}
+	}
+	sort.Strings(names)
+	var units, rest []benchUnit
+	for _, n := range names {
+		u := *byName[n]
+		u.profile = profileRun != nil && profileRun.MatchString(n)
+		if u.profile || mode == interleaveBenchmark {
+			units = append(units, u)
+		} else {
+			rest = append(rest, u)
+		}
+	}
+	if len(rest) > 0 {
+		units = append(units, groupBenchUnits(rest, sub, bs1, bs2)...)
+	}
+	return units, nil
+}
+
+// groupBenchUnits groups the benchmark units into a unit for those that run
+// on both suites and one for each suite for those that run on only one of
+// them, so that they are run in as few invocations as possible.
+func groupBenchUnits(units []benchUnit, sub string, bs1, bs2 *benchSuite) []benchUnit {
+	var groups []benchUnit
+	for _, suites := range [][]*benchSuite{{bs1, bs2}, {bs1}, {bs2}} {
+		var names []string
+		for _, u := range units {
+			if len(u.suites) == len(suites) && u.runsOn(suites[0]) {
+				names = append(names, regexp.QuoteMeta(u.name))
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		pattern := "^(" + strings.Join(names, "|") + ")$"
+		if sub != "" {
+			pattern += "/" + sub
+		}
+		groups = append(groups, benchUnit{pattern: pattern, suites: suites})
+	}
+	return groups
+}
+
+// listBenchmarks lists the top-level benchmarks in the test binary that match
+// the pattern.
+func listBenchmarks(ctx context.Context, bs *benchSuite, test, pattern string) ([]string, error) {
+	bin := bs.getTestBinary(test)
+	out, err := capture(ctx, bin, "-test.list", pattern)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing benchmarks in %s", bin)
+	}
+	var benches []string
+	for _, line := range strings.Split(out, "\n") {
+		// The list also includes tests, examples and fuzz targets.
+		if strings.HasPrefix(line, "Benchmark") {
+			benches = append(benches, line)
+		}
+	}
+	return benches, nil
+}
+
+// splitBenchPattern splits a -test.bench pattern into the part that matches
+// top-level benchmarks and the part that matches their sub-benchmarks, the
+// same way the testing package does. Slashes inside brackets or parentheses do
+// not separate the parts.
+func splitBenchPattern(pattern string) (top, sub string) {
+	var depth int
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '[', '(':
+			depth++
+		case ']', ')':
+			depth--
+		case '\\':
+			i++
+		case '/':
+			if depth == 0 {
+				return pattern[:i], pattern[i+1:]
+			}
+		}
+	}
+	return pattern, ""
+}
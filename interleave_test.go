@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitBenchPattern(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, top, sub string
+	}{
+		{".", ".", ""},
+		{"Foo/bar", "Foo", "bar"},
+		{"Foo/bar/baz", "Foo", "bar/baz"},
+		{"Foo[/]x/bar", "Foo[/]x", "bar"},
+		{"(Foo|Ba/r)/n=1", "(Foo|Ba/r)", "n=1"},
+		{`Foo\/x/bar`, `Foo\/x`, "bar"},
+	} {
+		top, sub := splitBenchPattern(tc.pattern)
+		if top != tc.top || sub != tc.sub {
+			t.Errorf("%q: expected (%q, %q), found (%q, %q)", tc.pattern, tc.top, tc.sub, top, sub)
+		}
+	}
+}
+
+func TestGroupBenchUnits(t *testing.T) {
+	bs1, bs2 := &benchSuite{ref: "old"}, &benchSuite{ref: "new"}
+	both := []*benchSuite{bs1, bs2}
+	units := []benchUnit{
+		{name: "BenchmarkA", suites: both},
+		{name: "BenchmarkB.x", suites: both},
+		{name: "BenchmarkOld", suites: []*benchSuite{bs1}},
+		{name: "BenchmarkNew", suites: []*benchSuite{bs2}},
+	}
+	groups := groupBenchUnits(units, "sub", bs1, bs2)
+	exp := []struct {
+		pattern string
+		suites  []*benchSuite
+	}{
+		{`^(BenchmarkA|BenchmarkB\.x)$/sub`, both},
+		{`^(BenchmarkOld)$/sub`, []*benchSuite{bs1}},
+		{`^(BenchmarkNew)$/sub`, []*benchSuite{bs2}},
+	}
+	if len(groups) != len(exp) {
+		t.Fatalf("expected %d groups, found %+v", len(exp), groups)
+	}
+	for i, g := range groups {
+		if g.pattern != exp[i].pattern || !reflect.DeepEqual(g.suites, exp[i].suites) {
+			t.Errorf("%d: expected %s on %v, found %s on %v", i, exp[i].pattern, exp[i].suites, g.pattern, g.suites)
+		}
+	}
+}
@@ -5,26 +5,30 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"io/fs"
 	"io/ioutil"
 	"math"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/google/pprof/profile"
-	"github.com/nvanbenschoten/benchdiff/google"
 	"github.com/nvanbenschoten/benchdiff/ui"
 	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
 	"golang.org/x/perf/benchstat"
 )
 
-const usage = `usage: benchdiff [--old <commit>] [--new <commit>] <pkgs>...`
+const usage = `usage: benchdiff [--old <commit>] [--new <commit>] <pkgs>...
+       benchdiff history [--metric <metric>] <benchmark>
+       benchdiff runs`
 
 const helpString = `benchdiff automates the process of running and comparing Go microbenchmarks
 across code changes.
@@ -46,33 +50,97 @@ When the --sheets flag is passed, benchdiff will search for a credentials file
 containing the service account key using the GOOGLE_APPLICATION_CREDENTIALS
 environment variable. See https://cloud.google.com/docs/authentication/production.
 
+If benchdiff is interrupted (e.g. with Ctrl-C), it stops the running benchmarks,
+returns the repository to its original branch, and outputs the results of the
+iterations that completed. The run can then be continued with --resume.
+
 Options:
   -n, --new       <commit>  measure the difference between this commit and old (default HEAD)
   -o, --old       <commit>  measure the difference between this commit and new (default new~)
                             'lastmerge' selects the most recent merge commit.
-  -r, --run       <regexp>  run only benchmarks matching regexp
+  -r, --run       <regexp>  run only benchmarks matching regexp. Packages without matching
+                            benchmarks in their test files are not built
+      --interleave <mode>   alternate between the old and new commit after each 'package' or
+                            each 'benchmark' (default package). With 'benchmark', the order of
+                            the benchmarks in each iteration is randomized
+      --order     <order>   order in which packages and commits are run: 'fixed' (sorted packages,
+                            old before new), 'alternate' (sorted packages, alternating which
+                            commit runs first), or 'random' (default fixed)
+      --seed      <n>       seed for the random choices of --order and --interleave. The seed of
+                            a run is printed in its header so that the run can be reproduced
   -c, --count     <n>       run tests and benchmarks n times (default 10)
+      --ci-target <n>       adaptively run each package more than --count times, until the 95%
+                            confidence interval of each benchmark's mean is within ±n (e.g. 0.01)
+      --max-count <n>       with --ci-target, run each package at most n times (default 50)
+      --max-time  <d>       with --ci-target, stop running a package after d
+      --parallel  <n>       run n test binaries at a time, each pinned to its own set of CPUs with
+                            GOMAXPROCS set to match (requires taskset). Both commits of a package
+                            run on the same CPU set (default 1)
+      --time-budget <d>     run as many iterations as fit in d, spread evenly across packages, up
+                            to --max-count per package. The cost of each package is estimated by
+                            running it once, and the plan and ETA are shown while running
   -d  --benchtime <d>       run each benchmark for duration d (default 1s)
       --cpuprofile          record and write cpu profiles
       --memprofile          record and write allocation profiles
       --mutexprofile        record and write mutex contention profiles
+      --blockprofile        record and write goroutine blocking profiles
+      --trace               record an execution trace of each profiled iteration. Traces are not merged
+      --memprofilerate <n>  with --memprofile, record one allocation per n bytes allocated
+      --blockprofilerate <n> with --blockprofile, record one blocking event per n nanoseconds blocked
+      --profile-count <n>   profile each package in n iterations of its own, run after its timed
+                            iterations so that profiling does not skew the results (default 1).
+                            For each profile, differential reports of new against old (top functions,
+                            flame graph and, with graphviz, call graph) are written and linked
+      --profile-run <re>    profile the benchmarks matching re one at a time, writing their merged
+                            profiles to <artifacts>/<test>/<benchmark>/ instead of merging the
+                            profiles of all benchmarks. Other benchmarks are not profiled.
+                            Implies --cpuprofile unless another profile is selected
+      --bench-timeout <d>   fail a benchmark binary invocation that runs for longer than d
+      --hang-timeout  <d>   fail a benchmark binary invocation that produces no output for d
+                            A goroutine dump of failed invocations is written to the artifacts dir
+      --strict-env          refuse to run if the machine is noisy. By default, benchdiff warns when
+                            the load average is high, the CPU frequency governor is not
+                            'performance', turbo boost is enabled or other processes are busy, and
+                            it annotates the output with the conditions observed during the run
   -t, --threshold <n>       exit with code 0 if all regressions are below threshold, else 1
-  -p, --previous-run <time> time of previous run; skip running benches and just (re)process previous run
+  -p, --previous-run <run>  time, index (see 'benchdiff runs'), or 'latest' of previous run; skip
+                            running benches and just (re)process previous run. Unless specified,
+                            the previous run's refs and packages are used
+      --resume    [<run>]   resume an interrupted run, selected like --previous-run (default latest),
+                            with the configuration that it was started with. The run must be
+                            selected with --resume=<run>, and no packages may be specified
       --post-checkout       an optional command to run after checking out each branch to
                             configure the git repo so that 'go build' succeeds
       --preview             show benchdiff text output while benchmarks are being run (default true)
-  -b  --bazel               build the test binaries with bazel
+      --exclude-failed      exclude benchmarks that failed or panicked on either ref from the
+                            comparison. Failures are always listed, and a benchmark that failed
+                            on only one ref causes a non-zero exit code
+  -b  --bazel               build the test binaries with bazel. The go_test targets of the packages
+                            are found with 'bazel query' and their binaries with 'bazel cquery'
+      --bazel-config  <c>   pass --config=<c> to bazel; may be repeated. Implies --bazel
+      --bazel-startup-flags <flags>
+                            startup flags to pass to bazel, like '--output_base=/tmp/b'. Implies
+                            --bazel
   -s  --sort      <order>   sort output by 'delta' (largest first) or 'name'
+      --by-pkg              group the benchmarks of each package in the output tables
       --csv                 output the results in a csv format
       --html                output the results in an HTML table
       --sheets              output the results to a new Google Sheets document
       --help                display this help
 
+Commands:
+  history                   show how a benchmark's metrics evolved across recorded runs
+  pprof                     open the pprof web UI on the differential profile of a previous run
+  runs                      list previous runs that can be reprocessed with --previous-run
+
 Example invocations:
   $ benchdiff --sheets ./pkg/...
   $ benchdiff --old=master~ --new=master --threshold=0.2 ./pkg/kv ./pkg/storage/...
   $ benchdiff --new=d1fbdb2 --run=Datum --count=2 --csv ./pkg/sql/...
-  $ benchdiff --new=6299bd4 --sheets --post-checkout='dev generate go' ./pkg/workload/...`
+  $ benchdiff --new=6299bd4 --sheets --post-checkout='dev generate go' ./pkg/workload/...
+  $ benchdiff history --metric=time/op Datum
+  $ benchdiff pprof --previous-run=latest --type=cpu
+  $ benchdiff --previous-run=latest --csv`
 
 // TODO: it's unclear whether G Suite Domain-wide Delegation is required for the
 // Google service account. If it is, add the following requirement to the help
@@ -80,170 +148,437 @@ Example invocations:
 //   3. G Suite Domain-wide Delegation must be enabled. See
 //    https://developers.google.com/identity/protocols/OAuth2ServiceAccount#delegatingauthority.
 
-type outputFmt int
-
-const (
-	_ outputFmt = iota
-	// Output the benchmark comparison in a text format to stdout.
-	//
-	// Example:
-	//   name         old time/op    new time/op    delta
-	//   String-8       68.6ns ± 0%    68.2ns ± 0%   ~     (p=1.000 n=1+1)
-	//   FromBytes-8    4.92ns ± 0%    4.97ns ± 0%   ~     (p=1.000 n=1+1)
-	text
-	// Output the benchmark comparison in a csv format to stdout.
-	//
-	// Example:
-	//   name,old time/op (ns/op),±,new time/op (ns/op),±,delta,±
-	//   String-8,6.82000E+01,0%,6.76000E+01,0%,~,(p=1.000 n=1+1)
-	//   FromBytes-8,5.01000E+00,0%,4.95000E+00,0%,~,(p=1.000 n=1+1)
-	csv
-	// Output the benchmark comparison in an HTML format to stdout.
-	//
-	// Example:
-	//   <table class='benchstat oldnew'>
-	//   <tr class='configs'><th><th>old<th>new
-	//   <tbody>
-	//   <tr><th><th colspan='2' class='metric'>time/op<th>delta
-	//   <tr class='unchanged'><td>String-8<td>70.1ns ± 0%<td>69.6ns ± 0%<td class='nodelta'>~<td class='note'>(p=1.000 n=1&#43;1)
-	//   <tr class='unchanged'><td>FromBytes-8<td>5.42ns ± 0%<td>5.05ns ± 0%<td class='nodelta'>~<td class='note'>(p=1.000 n=1&#43;1)
-	//   <tr><td>&nbsp;
-	//   </tbody>
-	//   </table>
-	html
-	// Output the benchmark comaprison in a Google Sheets format and print
-	// the sheet's URL to stdout. When in this mode, the comparison is also
-	// printed as text to stdout.
-	//
-	// Example:
-	//   name         old time/op    new time/op    delta
-	//   String-8       68.6ns ± 0%    68.2ns ± 0%   ~     (p=1.000 n=1+1)
-	//   FromBytes-8    4.92ns ± 0%    4.97ns ± 0%   ~     (p=1.000 n=1+1)
-	//
-	//   generated sheet: https://docs.google.com/spreadsheets/...
-	sheets
-)
-
 const timeFormat = "2006-01-02T15_04_05Z07:00"
 
 func main() {
-	if err := run(context.Background()); err != nil {
+	// Cancel the context on SIGINT or SIGTERM. This stops any running child
+	// processes and allows benchdiff to clean up after itself and to output
+	// the partial results that it has gathered. A second signal terminates
+	// benchdiff immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if err := run(ctx); err != nil {
 		fmt.Fprintf(os.Stderr, "fatal: %s\n", err)
 		os.Exit(1)
 	}
 }
 
+// subcommands maps the name of each benchdiff subcommand to its entry point.
+// The entry point is passed the arguments following the subcommand's name.
+var subcommands = map[string]func(ctx context.Context, args []string) error{
+	"history": runHistory,
+	"pprof":   runPProfCmd,
+	"runs":    runListRuns,
+}
+
 func run(ctx context.Context) error {
-	var help, outCSV, outHTML, outSheets bool
-	var oldRef, newRef, order, postChck, runPattern, benchTime, previousRun string
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			return cmd(ctx, os.Args[2:])
+		}
+	}
+
+	var help bool
+	var oldRef, newRef, order, postChck, runPattern, benchTime, previousRun, resume string
+	var interleave, runOrder, profileRun string
+	var seed int64
 	var itersPerTest int
-	var cpuProfile, memProfile, mutexProfile bool
+	var profiles profileOptions
 	var threshold float64
+	var adaptive adaptiveOptions
+	var timeBudget time.Duration
+	var parallel, profileCount int
+	var benchTimeout, hangTimeout time.Duration
 	var useBazel bool
-	var preview bool
+	var bazel bazelOptions
+	var bazelStartupFlags string
+	var preview, excludeFailed, strictEnv, byPkg bool
 
 	pflag.Usage = func() { fmt.Fprintln(os.Stderr, usage) }
 	pflag.BoolVarP(&help, "help", "h", false, "")
-	pflag.BoolVarP(&outCSV, "csv", "", false, "")
-	pflag.BoolVarP(&outHTML, "html", "", false, "")
-	pflag.BoolVarP(&outSheets, "sheets", "", false, "")
+	outFlags := make([]bool, len(exporters))
+	for i, spec := range exporters {
+		pflag.BoolVarP(&outFlags[i], spec.flag, "", false, "")
+	}
 	pflag.BoolVarP(&useBazel, "bazel", "b", false, "")
+	pflag.StringSliceVarP(&bazel.configs, "bazel-config", "", nil, "")
+	pflag.StringVarP(&bazelStartupFlags, "bazel-startup-flags", "", "", "")
 	pflag.StringVarP(&oldRef, "old", "o", "", "")
 	pflag.StringVarP(&newRef, "new", "n", "", "")
 	pflag.StringVarP(&order, "sort", "s", "delta", "")
+	pflag.BoolVarP(&byPkg, "by-pkg", "", false, "")
 	pflag.StringVarP(&postChck, "post-checkout", "", "", "")
 	pflag.StringVarP(&runPattern, "run", "r", ".", "")
 	pflag.IntVarP(&itersPerTest, "count", "c", 10, "")
 	pflag.StringVarP(&benchTime, "benchtime", "d", "", "")
-	pflag.BoolVarP(&cpuProfile, "cpuprofile", "", false, "")
-	pflag.BoolVarP(&memProfile, "memprofile", "", false, "")
-	pflag.BoolVarP(&mutexProfile, "mutexprofile", "", false, "")
+	pflag.StringVarP(&interleave, "interleave", "", interleavePackage, "")
+	pflag.StringVarP(&runOrder, "order", "", orderFixed, "")
+	pflag.Int64VarP(&seed, "seed", "", 0, "")
+	profileFlags := make([]bool, len(profileTypes))
+	for i, pt := range profileTypes {
+		pflag.BoolVarP(&profileFlags[i], pt.flag, "", false, "")
+	}
+	pflag.IntVarP(&profiles.memRate, "memprofilerate", "", 0, "")
+	pflag.IntVarP(&profiles.blockRate, "blockprofilerate", "", 0, "")
+	pflag.StringVarP(&profileRun, "profile-run", "", "", "")
+	pflag.IntVarP(&profileCount, "profile-count", "", 1, "")
+	pflag.DurationVarP(&benchTimeout, "bench-timeout", "", 0, "")
+	pflag.DurationVarP(&hangTimeout, "hang-timeout", "", 0, "")
 	pflag.Float64VarP(&threshold, "threshold", "t", -1, "")
+	pflag.Float64VarP(&adaptive.ciTarget, "ci-target", "", 0, "")
+	pflag.IntVarP(&adaptive.maxCount, "max-count", "", 50, "")
+	pflag.DurationVarP(&adaptive.maxTime, "max-time", "", 0, "")
+	pflag.DurationVarP(&timeBudget, "time-budget", "", 0, "")
+	pflag.IntVarP(&parallel, "parallel", "", 1, "")
 	pflag.StringVarP(&previousRun, "previous-run", "p", "", "")
+	pflag.StringVarP(&resume, "resume", "", "", "")
+	pflag.Lookup("resume").NoOptDefVal = "latest"
 	pflag.BoolVarP(&preview, "preview", "", true, "")
+	pflag.BoolVarP(&excludeFailed, "exclude-failed", "", false, "")
+	pflag.BoolVarP(&strictEnv, "strict-env", "", false, "")
 	pflag.Parse()
 	prArgs := pflag.Args()
+	for i, pt := range profileTypes {
+		if profileFlags[i] {
+			profiles.types = append(profiles.types, pt)
+		}
+	}
+	bazel.startupFlags = strings.Fields(bazelStartupFlags)
+	if len(bazel.configs) > 0 || len(bazel.startupFlags) > 0 {
+		useBazel = true
+	}
 
 	if help {
 		return runHelp(ctx)
 	}
-	if len(prArgs) == 0 && previousRun == "" {
+	if len(prArgs) == 0 && previousRun == "" && resume == "" {
 		return runHelp(ctx)
 	}
+	if previousRun != "" && resume != "" {
+		return errors.New("--previous-run and --resume incompatible")
+	}
+	if resume != "" && len(prArgs) > 0 {
+		// A run is resumed with the packages that it was started with. As
+		// --resume has an optional value, "--resume 3" is "--resume" followed
+		// by a package.
+		return errors.Errorf("--resume does not take packages, found %s; select a run with --resume=<run>",
+			strings.Join(prArgs, " "))
+	}
+	if err := checkInterleave(interleave); err != nil {
+		return err
+	}
+	if err := checkOrder(runOrder); err != nil {
+		return err
+	}
+	if adaptive.maxCount < 1 {
+		return errors.New("--max-count must be at least 1")
+	}
+	if profileCount < 1 {
+		return errors.New("--profile-count must be at least 1")
+	}
+	if parallel < 1 {
+		return errors.New("--parallel must be at least 1")
+	}
+	if parallel > 1 && timeBudget > 0 {
+		return errors.New("--parallel and --time-budget incompatible")
+	}
+	if !pflag.Lookup("seed").Changed {
+		seed = time.Now().UnixNano()
+	}
 	pkgFilter := prArgs
 	sort.Strings(pkgFilter)
 
-	// Parse the output format.
-	var out outputFmt
-	var srv *google.Service
-	var err error
-	switch {
-	case outCSV:
-		if outHTML {
-			return errors.New("--csv and --html incompatible")
-		} else if outSheets {
-			return errors.New("--csv and --sheets incompatible")
-		}
-		out = csv
-	case outHTML:
-		if outSheets {
-			return errors.New("--html and --sheets incompatible")
-		}
-		out = html
-	case outSheets:
-		out = sheets
-		// Init the Google service ASAP to detect credential issues.
-		if srv, err = google.New(ctx); err != nil {
+	// Find the previous run, if one was specified. Unless overridden, its refs
+	// and package filter are used when reprocessing it.
+	var prev prevRun
+	if previousRun != "" {
+		var err error
+		if prev, err = findPrevRun(ctx, previousRun); err != nil {
 			return err
 		}
-	default:
-		out = text
+		if oldRef == "" {
+			oldRef = prev.md.OldRef
+		}
+		if newRef == "" {
+			newRef = prev.md.NewRef
+		}
+		if len(pkgFilter) == 0 {
+			pkgFilter = prev.md.PkgFilter
+		}
+	}
+
+	// Find the interrupted run, if one was specified. It is resumed with the
+	// same configuration that it was started with.
+	if resume != "" {
+		var err error
+		if prev, err = findPrevRun(ctx, resume); err != nil {
+			return err
+		}
+		if !prev.hasManifest {
+			return errors.Errorf("cannot resume run at %s without a run manifest",
+				prev.md.Time.Format(timeFormat))
+		}
+		oldRef, newRef = prev.md.OldRef, prev.md.NewRef
+		pkgFilter = prev.md.PkgFilter
+		useBazel = prev.md.Bazel
+		bazel.configs = prev.md.BazelConfigs
+		bazel.startupFlags = prev.md.BazelStartupFlags
+		runPattern = prev.md.RunPattern
+		benchTime = prev.md.BenchTime
+		itersPerTest = prev.md.Count
+		if prev.md.Interleave != "" {
+			interleave = prev.md.Interleave
+		}
+		if prev.md.Order != "" {
+			runOrder, seed = prev.md.Order, prev.md.Seed
+		}
+		adaptive.ciTarget = prev.md.CITarget
+		adaptive.maxTime = prev.md.MaxTime
+		if prev.md.MaxCount != 0 {
+			adaptive.maxCount = prev.md.MaxCount
+		}
+		// The resumed run gets the full time budget again.
+		timeBudget = prev.md.TimeBudget
+		if prev.md.Parallel != 0 {
+			parallel = prev.md.Parallel
+		}
+		profiles.types = nil
+		for _, name := range prev.md.Profiles {
+			if pt, ok := lookupProfileType(name); ok {
+				profiles.types = append(profiles.types, pt)
+			}
+		}
+		profiles.memRate = prev.md.MemRate
+		profiles.blockRate = prev.md.BlockRate
+		profileRun = prev.md.ProfileRun
+		if prev.md.ProfileCount != 0 {
+			profileCount = prev.md.ProfileCount
+		}
+	}
+
+	// Parse the benchmarks to profile on their own, which a resumed run takes
+	// from its manifest.
+	var profileRunRE *regexp.Regexp
+	if profileRun != "" {
+		var err error
+		if profileRunRE, err = regexp.Compile(profileRun); err != nil {
+			return errors.Wrap(err, "parsing --profile-run")
+		}
+		if len(profiles.types) == 0 {
+			cpu, _ := lookupProfileType("cpu")
+			profiles.types = []profileType{cpu}
+		}
+	}
+
+	// Parse the output format.
+	var out exporter = textExporter{}
+	var outSpec exporterSpec
+	for i, spec := range exporters {
+		if !outFlags[i] {
+			continue
+		}
+		if outSpec.flag != "" {
+			return errors.Errorf("--%s and --%s incompatible", outSpec.flag, spec.flag)
+		}
+		outSpec, out = spec, spec.new()
+	}
+	// Init the exporter ASAP to detect credential issues.
+	if err := out.init(ctx); err != nil {
+		return err
 	}
 
 	// Parse the specified git refs.
-	oldRef, newRef, err = parseGitRefs(oldRef, newRef)
+	oldRef, newRef, err := parseGitRefs(ctx, oldRef, newRef)
 	if err != nil {
 		return err
 	}
-	oldSubject, err := subjectForRef(oldRef)
+	oldSubject, err := subjectForRef(ctx, oldRef)
 	if err != nil {
 		return err
 	}
-	newSubject, err := subjectForRef(newRef)
+	newSubject, err := subjectForRef(ctx, newRef)
 	if err != nil {
 		return err
 	}
 
 	// Build the benchmark suites.
-	oldSuite := makeBenchSuite(oldRef, oldSubject, useBazel)
-	newSuite := makeBenchSuite(newRef, newSubject, useBazel)
+	var bazelOpts *bazelOptions
+	if useBazel {
+		bazelOpts = &bazel
+	}
+	oldSuite := makeBenchSuite(oldRef, oldSubject, bazelOpts)
+	newSuite := makeBenchSuite(newRef, newSubject, bazelOpts)
 	defer oldSuite.close()
 	defer newSuite.close()
 
-	printHeader(os.Stdout, oldSuite, newSuite)
-
+	// Describe the machine that the benchmarks run on. A run that is resumed
+	// on a different machine mixes results that are not comparable.
+	var host *hostInfo
+	if previousRun != "" {
+		runOrder, seed = prev.md.Order, prev.md.Seed
+		host = prev.md.Host
+	} else {
+		host = gatherHostInfo(ctx)
+		if resume != "" && prev.md.Host != nil && !host.sameMachine(prev.md.Host) {
+			fmt.Fprintf(os.Stderr, "warning: resuming a run started on a different machine (%s)\n",
+				prev.md.Host.summary())
+		}
+	}
+	printHeader(os.Stdout, oldSuite, newSuite, host, runOrder, seed)
+
+	md := runMetadata{
+		OldRef:       oldSuite.ref,
+		NewRef:       newSuite.ref,
+		OldSubject:   oldSuite.subject,
+		NewSubject:   newSuite.subject,
+		PkgFilter:    pkgFilter,
+		Args:         os.Args[1:],
+		Bazel:        useBazel,
+		RunPattern:   runPattern,
+		BenchTime:    benchTime,
+		Count:        itersPerTest,
+		Interleave:   interleave,
+		Order:        runOrder,
+		Seed:         seed,
+		CITarget:     adaptive.ciTarget,
+		MaxCount:     adaptive.maxCount,
+		MaxTime:      adaptive.maxTime,
+		TimeBudget:   timeBudget,
+		Parallel:     parallel,
+		Host:         host,
+		Profiles:     profiles.names(),
+		ProfileRun:   profileRun,
+		MemRate:      profiles.memRate,
+		ProfileCount: profileCount,
+		BlockRate:    profiles.blockRate,
+	}
+	if useBazel {
+		md.BazelConfigs, md.BazelStartupFlags = bazel.configs, bazel.startupFlags
+	}
+	var interrupted bool
 	if previousRun == "" {
-		if err := buildBenches(ctx, pkgFilter, postChck, &oldSuite, &newSuite); err != nil {
+		ckpt := makeRunCheckpoint()
+		if resume != "" {
+			// Append to the output files of the interrupted run.
+			md = prev.md
+		} else {
+			// Used to uniquely name artifact files, which have second granularity.
+			md.Time = time.Now().Truncate(time.Second)
+		}
+
+		// Check that the machine is quiet before building and running the
+		// benchmarks, and keep an eye on it while they run.
+		mon, warnings := startEnvMonitor()
+		defer mon.stop()
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "warning: noisy environment: %s\n", w)
+		}
+		if strictEnv && len(warnings) > 0 {
+			return errors.New("refusing to run in a noisy environment (--strict-env)")
+		}
+		if err := buildBenches(ctx, pkgFilter, runPattern, postChck, md.Time, &oldSuite, &newSuite); err != nil {
+			return err
+		}
+		if resume != "" {
+			if ckpt, err = readCheckpoint(&newSuite, md.Time); err != nil {
+				return err
+			}
+			if err := ckpt.restore(&oldSuite, &newSuite); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Resuming run; old=%s, new=%s\n", oldSuite.outFile.Name(), newSuite.outFile.Name())
+		} else if err := writeManifest(md, &oldSuite, &newSuite); err != nil {
 			return err
 		}
 
 		// Run the benchmarks.
-		tests := oldSuite.intersectTests(&newSuite)
-		err = runCmpBenches(
-			ctx, &oldSuite, &newSuite, tests.sorted(), runPattern,
-			benchTime, cpuProfile, memProfile, mutexProfile, itersPerTest, preview,
-		)
-		if err != nil {
+		opts := benchOptions{
+			runPattern:    runPattern,
+			benchTime:     benchTime,
+			profiles:      profiles,
+			itersPerTest:  itersPerTest,
+			interleave:    interleave,
+			order:         runOrder,
+			seed:          seed,
+			preview:       preview,
+			excludeFailed: excludeFailed,
+			benchTimeout:  benchTimeout,
+			hangTimeout:   hangTimeout,
+			adaptive:      adaptive,
+			timeBudget:    timeBudget,
+			profileRun:    profileRunRE,
+			profileCount:  profileCount,
+		}
+		tests := orderTests(oldSuite.intersectTests(&newSuite).sorted(), runOrder, seed)
+		if parallel > 1 {
+			var sets []cpuSet
+			if sets, err = makeCPUSets(ctx, parallel); err != nil {
+				return err
+			}
+			var assigned map[string]string
+			assigned, err = runCmpBenchesParallel(
+				ctx, &oldSuite, &newSuite, tests, opts, md.Time, &ckpt, sets,
+			)
+			// Record which CPU set each package ran on. When resuming, the
+			// packages that completed earlier keep their recorded CPU sets.
+			if md.CPUSets == nil {
+				md.CPUSets = make(map[string]string)
+			}
+			for pkg, cpus := range assigned {
+				md.CPUSets[pkg] = cpus
+			}
+		} else {
+			err = runCmpBenches(ctx, &oldSuite, &newSuite, tests, opts, md.Time, &ckpt)
+		}
+		// Record the conditions observed during the run. When resuming, they
+		// are added to those observed before the run was interrupted.
+		md.Env = md.Env.merge(mon.stop())
+		if md.Host == nil {
+			md.Host = host
+		}
+		for _, bs := range []*benchSuite{&oldSuite, &newSuite} {
+			if err := md.Host.recordBenchConfig(bs); err != nil {
+				return err
+			}
+		}
+		if err := writeManifest(md, &oldSuite, &newSuite); err != nil {
 			return err
 		}
-	} else {
-		// Find output files for the given run.
-		t, err := time.Parse(timeFormat, previousRun)
 		if err != nil {
-			return err
+			if ctx.Err() == nil {
+				return err
+			}
+			// The run was interrupted. Discard the output of the incomplete
+			// iteration and process the output of the completed iterations
+			// using a fresh context.
+			if err := ckpt.restore(&oldSuite, &newSuite); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "\ninterrupted; processing partial results "+
+				"(resume with --resume=%s)\n\n", md.Time.Format(timeFormat))
+			interrupted = true
+			ctx = context.Background()
+		}
+	} else {
+		// The run's configuration is described by its manifest, not by the
+		// flags used to reprocess it.
+		md.Time = prev.md.Time
+		if prev.hasManifest {
+			md.Args = prev.md.Args
+			md.Bazel = prev.md.Bazel
+			md.RunPattern = prev.md.RunPattern
+			md.BenchTime = prev.md.BenchTime
+			md.Count = prev.md.Count
+			md.Interleave = prev.md.Interleave
+			md.Env = prev.md.Env
+			md.Profiles = prev.md.Profiles
 		}
 
 		// Install existing artifacts into benchSuites.
+		t := md.Time
 		oldSuite.artDir = testArtifactsDir(oldSuite.ref)
 		oldSuite.outFile, err = os.Open(oldSuite.getOutputFile(t))
 		if err != nil {
@@ -258,14 +593,35 @@ func run(ctx context.Context) error {
 		fmt.Fprintf(os.Stderr, "Found previous run; old=%s, new=%s\n", oldSuite.outFile.Name(), newSuite.outFile.Name())
 	}
 	// Process the benchmark output.
-	res, err := processBenchOutput(ctx, os.Stdout, &oldSuite, &newSuite, order == "name", out, pkgFilter, srv)
+	res, link, err := processBenchOutput(
+		ctx, os.Stdout, &oldSuite, &newSuite, order == "name", byPkg, excludeFailed, out, md,
+	)
 	if err != nil {
 		return err
 	}
-	logProfileLocations(&oldSuite, &newSuite, cpuProfile, memProfile, mutexProfile)
+	if link != "" {
+		fmt.Printf("\n%s: %s\n", outSpec.link, link)
+	}
+	if err := logProfileLocations(&oldSuite, &newSuite, md); err != nil {
+		return err
+	}
+	if interrupted {
+		// Don't record partial runs. They are recorded once resumed.
+		return errors.New("benchmark run interrupted")
+	}
+
+	// Record the run in the local results database. Failing to do so is not
+	// fatal, as the results have already been output.
+	if err := recordRun(md, &oldSuite, &newSuite, res.tables); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: recording run in results database: %v\n", err)
+	}
 
-	// Determine whether any tests exceeded the allowable regression threshold.
-	return checkPassing(threshold, res)
+	// Determine whether any benchmarks were broken by the new ref, or whether
+	// any exceeded the allowable regression threshold.
+	if err := checkFailures(res.failures); err != nil {
+		return err
+	}
+	return checkPassing(threshold, res.tables)
 }
 
 func runHelp(ctx context.Context) error {
@@ -275,41 +631,41 @@ func runHelp(ctx context.Context) error {
 	return nil
 }
 
-func parseGitRefs(oldRef, newRef string) (string, string, error) {
+func parseGitRefs(ctx context.Context, oldRef, newRef string) (string, string, error) {
 	var err error
 	if newRef == "" {
-		newRef, err = getCurRef()
+		newRef, err = getCurRef(ctx)
 		if err != nil {
 			return "", "", err
 		}
 	} else {
-		newRef, err = getRefAsSHA(newRef)
+		newRef, err = getRefAsSHA(ctx, newRef)
 		if err != nil {
 			return "", "", err
 		}
 	}
-	newRef = shortenRef(newRef)
-	if ok, err := checkValidRef(newRef); err != nil {
+	newRef = shortenRef(ctx, newRef)
+	if ok, err := checkValidRef(ctx, newRef); err != nil {
 		return "", "", err
 	} else if !ok {
 		return "", "", errors.Errorf("invalid git ref %q", newRef)
 	}
 
 	if oldRef == "" {
-		oldRef, err = getPrevRef(newRef)
+		oldRef, err = getPrevRef(ctx, newRef)
 		if err != nil {
 			return "", "", err
 		}
 	} else if oldRef == "lastmerge" {
-		oldRef, err = capture("git", "log", "-n", "1", "--merges", "--format=%H", newRef)
+		oldRef, err = capture(ctx, "git", "log", "-n", "1", "--merges", "--format=%H", newRef)
 	} else {
-		oldRef, err = getRefAsSHA(oldRef)
+		oldRef, err = getRefAsSHA(ctx, oldRef)
 		if err != nil {
 			return "", "", err
 		}
 	}
-	oldRef = shortenRef(oldRef)
-	if ok, err := checkValidRef(oldRef); err != nil {
+	oldRef = shortenRef(ctx, oldRef)
+	if ok, err := checkValidRef(ctx, oldRef); err != nil {
 		return "", "", err
 	} else if !ok {
 		return "", "", errors.Errorf("invalid git ref %q", oldRef)
@@ -318,143 +674,496 @@ func parseGitRefs(oldRef, newRef string) (string, string, error) {
 	return oldRef, newRef, nil
 }
 
-func buildBenches(ctx context.Context, pkgFilter []string, postChck string, bss ...*benchSuite) error {
-	// Get the current branch so we can revert to it after, if possible.
-	if ref, ok, err := getCurSymbolicRef(); err != nil {
+func buildBenches(
+	ctx context.Context, pkgFilter []string, runPattern, postChck string, now time.Time, bss ...*benchSuite,
+) error {
+	// Get the current branch so we can revert to it after, if possible. Do so
+	// even if the context has been canceled, so that an interrupted build does
+	// not leave the repository on a detached ref.
+	if ref, ok, err := getCurSymbolicRef(ctx); err != nil {
 		return err
 	} else if ok {
-		defer checkoutRef(ref, "")
+		defer checkoutRef(context.Background(), ref, "")
 	}
-	now := time.Now() // used to uniquely name artifact files
 	for _, bs := range bss {
-		if err := bs.build(pkgFilter, postChck, now); err != nil {
+		if err := bs.build(ctx, pkgFilter, runPattern, postChck, now); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// benchOptions configures how benchmarks are run.
+type benchOptions struct {
+	runPattern, benchTime  string
+	profiles               profileOptions
+	itersPerTest           int
+	interleave             string
+	preview, excludeFailed bool
+	// benchTimeout, if non-zero, limits the duration of each invocation of a
+	// test binary.
+	benchTimeout time.Duration
+	// hangTimeout, if non-zero, is how long a test binary can go without
+	// producing output before it is considered hung.
+	hangTimeout time.Duration
+	// order and seed determine the order in which packages and refs are run.
+	order string
+	seed  int64
+	// adaptive configures adaptive iteration counts, with itersPerTest as the
+	// minimum count.
+	adaptive adaptiveOptions
+	// timeBudget, if non-zero, is the time in which all iterations must
+	// complete. The iterations are spread evenly across packages, up to
+	// adaptive.maxCount iterations each.
+	timeBudget time.Duration
+	// profileRun, if non-nil, selects the benchmarks to profile. Each is run
+	// on its own and gets its own profiles, and the others are not profiled.
+	profileRun *regexp.Regexp
+	// profileCount is the number of profiled iterations of each test binary,
+	// which run after its timed iterations if any profiles are enabled.
+	profileCount int
+}
+
+// profiled returns whether the unit's profiles are recorded.
+func (o benchOptions) profiled(u benchUnit) bool {
+	return o.profileRun == nil || u.profile
+}
+
 func runCmpBenches(
 	ctx context.Context,
 	bs1, bs2 *benchSuite,
 	tests []string,
-	runPattern, benchTime string,
-	cpuProfile, memProfile, mutexProfile bool,
-	itersPerTest int,
-	preview bool,
+	opts benchOptions,
+	runTime time.Time,
+	ckpt *runCheckpoint,
 ) error {
+	r := newBenchRunner(bs1, bs2, opts, runTime, ckpt)
 	w := ui.NewWriter(os.Stderr)
-	for i, t := range tests {
-		pkg := testBinToPkg(t)
-		m := w.GetMark()
-		for j := 0; j < itersPerTest; j++ {
-			err := func() error {
-				w.ClearToMark(m)
-				if preview && j > 0 {
-					_, err := processBenchOutput(ctx, w, bs1, bs2, true, text, tests, nil)
-					if err != nil {
-						return err
-					}
-					fmt.Fprintln(w)
-				}
+	m := w.GetMark()
+	defer w.ClearToMark(m)
 
-				pkgFrac := ui.Fraction(i+1, len(tests))
-				iterFrac := ui.Fraction(j+1, itersPerTest)
+	var budget *timeBudget
+	if opts.timeBudget > 0 {
+		budget = newTimeBudget(opts.timeBudget, opts.adaptive.maxCount, r.profileIterations())
+	}
+	status := func(remaining []string) string {
+		if budget == nil {
+			return ""
+		}
+		return " (" + budget.status(remaining, ckpt.Completed, ckpt.Profiled) + ")"
+	}
 
-				spinner := ui.StartSpinner(w, fmt.Sprintf(
-					"running benchmarks:\npkg=%s iter=%s %s", pkgFrac, iterFrac, pkg,
-				))
-				defer spinner.Stop()
+	// runIter runs the next timed or profiled iteration of the i'th test
+	// binary.
+	runIter := func(i int, t, iterFrac, status string, profiled bool) error {
+		w.ClearToMark(m)
+		if opts.preview && (i > 0 || ckpt.Completed[t] > 0) {
+			_, _, err := processBenchOutput(
+				ctx, w, bs1, bs2, true, false /* byPkg */, opts.excludeFailed, textExporter{}, runMetadata{},
+			)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(w)
+		}
 
-				for _, b := range []*benchSuite{bs1, bs2} {
-					if err := b.unlinkProfiles(); err != nil {
-						return err
-					}
-				}
+		pkgFrac := ui.Fraction(i+1, len(tests))
+		iterLabel := "iter"
+		if profiled {
+			iterLabel = "profile"
+		}
+		spinner := ui.StartSpinner(w, fmt.Sprintf(
+			"running benchmarks%s:\npkg=%s %s=%s %s", status, pkgFrac, iterLabel, iterFrac, bs1.testPkg(t),
+		))
+		defer spinner.Stop()
 
-				// Interleave test suite runs instead of using -count=itersPerTest. The
-				// idea is that this reduces the chance that we pick up external noise
-				// with a time correlation.
-				for _, b := range []*benchSuite{bs1, bs2} {
-					spinner.Update(" " + b.ref)
-					if err := runSingleBench(b, t, runPattern, benchTime, cpuProfile, memProfile, mutexProfile); err != nil {
-						return err
-					}
-					if err := b.mergeProfiles(cpuProfile, memProfile, mutexProfile); err != nil {
-						return err
-					}
-				}
-				return nil
-			}()
-			if err != nil {
+		start := time.Now()
+		if err := r.run(ctx, t, nil /* cpus */, spinner.Update, profiled); err != nil {
+			return err
+		}
+		if budget != nil && !profiled {
+			budget.observe(t, time.Since(start))
+		}
+		return nil
+	}
+
+	// With a time budget, first estimate the cost of each package by running
+	// one iteration of it. This also ensures that every package has results
+	// even if the budget is too small to run them all more than once.
+	if budget != nil {
+		for i, t := range tests {
+			if budget.estimated(t) || !time.Now().Before(budget.deadline) {
+				continue
+			}
+			iterFrac := fmt.Sprintf("%d (estimating cost)", ckpt.Completed[t]+1)
+			if err := runIter(i, t, iterFrac, status(nil), false /* profiled */); err != nil {
 				return err
 			}
 		}
-		w.ClearToMark(m)
 	}
-	return nil
-}
 
-func (bs *benchSuite) unlinkProfiles() error {
-	return filepath.WalkDir(bs.artDir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+	var unprofiled []string
+	for i, t := range tests {
+		if budget != nil {
+			// Re-plan with the latest cost estimates and the time left.
+			budget.plan(tests[i:], ckpt.Completed, ckpt.Profiled)
 		}
-		if d.IsDir() || !strings.HasSuffix(d.Name(), ".prof") {
-			return nil
+		p := r.newIterPolicy(t, budget)
+		// Skip the iterations that were completed before the run was resumed.
+		for j := ckpt.Completed[t]; ; j++ {
+			if ok, err := p.more(j); err != nil {
+				return err
+			} else if !ok {
+				break
+			}
+			if err := runIter(i, t, p.progress(j), status(tests[i:]), false /* profiled */); err != nil {
+				return err
+			}
 		}
-		if err := os.Remove(d.Name()); err != nil && !os.IsNotExist(err) {
-			return err
+		// Profile the package in iterations of its own so that profiling
+		// doesn't skew the timings.
+		for k := ckpt.Profiled[t]; k < r.profileIterations(); k++ {
+			if budget != nil && !budget.fits(t) {
+				unprofiled = append(unprofiled, bs1.testPkg(t))
+				break
+			}
+			iterFrac := ui.Fraction(k+1, r.profileIterations())
+			if err := runIter(i, t, iterFrac, status(tests[i:]), true /* profiled */); err != nil {
+				return err
+			}
 		}
-		return nil
-	})
+	}
+	if len(unprofiled) > 0 {
+		w.ClearToMark(m)
+		fmt.Fprintf(os.Stderr, "warning: time budget exhausted; skipped profiling of %s\n",
+			strings.Join(unprofiled, ", "))
+	}
+	return nil
+}
+
+// benchRunner runs iterations of test binaries on both benchmark suites.
+type benchRunner struct {
+	bs1, bs2 *benchSuite
+	opts     benchOptions
+	runTime  time.Time
+
+	// mu serializes access to the suites' output files and profiles and to the
+	// run's checkpoint, which are shared by test binaries running in parallel.
+	mu    sync.Mutex
+	ckpt  *runCheckpoint
+	units map[string][]benchUnit
 }
 
-func (bs *benchSuite) mergeProfiles(cpuProfile, memProfile, mutexProfile bool) error {
-	type tup struct {
-		from, into string
+func newBenchRunner(
+	bs1, bs2 *benchSuite, opts benchOptions, runTime time.Time, ckpt *runCheckpoint,
+) *benchRunner {
+	return &benchRunner{
+		bs1:     bs1,
+		bs2:     bs2,
+		opts:    opts,
+		runTime: runTime,
+		ckpt:    ckpt,
+		units:   make(map[string][]benchUnit),
 	}
-	var tups []tup
-	if cpuProfile {
-		tups = append(tups, tup{"cpu_last", "cpu"})
+}
+
+// completed returns the number of completed iterations of the test binary.
+func (r *benchRunner) completed(test string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ckpt.Completed[test]
+}
+
+// profiledCompleted returns the number of completed profiled iterations of the
+// test binary.
+func (r *benchRunner) profiledCompleted(test string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ckpt.Profiled[test]
+}
+
+// profileIterations returns the number of profiled iterations to run of each
+// test binary, after its timed iterations.
+func (r *benchRunner) profileIterations() int {
+	if len(r.opts.profiles.types) == 0 {
+		return 0
 	}
-	if memProfile {
-		tups = append(tups, tup{"mem_last", "mem"})
+	return r.opts.profileCount
+}
+
+// runIteration runs the next timed iteration of the test binary on both
+// suites. If cpus is not nil, the test binary is pinned to the CPU set. The
+// output of the iteration is buffered and only appended to the output files,
+// along with an updated checkpoint, once the iteration is complete, so the
+// output files never contain partial iterations.
+func (r *benchRunner) runIteration(
+	ctx context.Context, t string, cpus *cpuSet, progress func(string),
+) error {
+	return r.run(ctx, t, cpus, progress, false /* profiled */)
+}
+
+// runProfileIteration runs the next profiled iteration of the test binary on
+// both suites. Profiling skews the timings, so the output of profiled
+// iterations is kept out of the output files that are compared. Only the
+// profiles are merged.
+func (r *benchRunner) runProfileIteration(
+	ctx context.Context, t string, cpus *cpuSet, progress func(string),
+) error {
+	return r.run(ctx, t, cpus, progress, true /* profiled */)
+}
+
+func (r *benchRunner) run(
+	ctx context.Context, t string, cpus *cpuSet, progress func(string), profiled bool,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	if mutexProfile {
-		tups = append(tups, tup{"mutex_last", "mutex"})
+	j := r.completed(t)
+	seed := j
+	if profiled {
+		j = r.profiledCompleted(t)
+		// Don't repeat the order of the timed iterations.
+		seed = -1 - j
 	}
-	for _, cur := range tups {
-		dest := bs.getProfileFile(cur.into)
-		var srcs []*profile.Profile
-		if _, err := os.Stat(dest); err == nil {
-			mergedBytes, err := os.ReadFile(dest)
+	r.mu.Lock()
+	units, ok := r.units[t]
+	r.mu.Unlock()
+	if !ok {
+		var err error
+		units, err = listBenchUnits(
+			ctx, r.opts.interleave, r.bs1, r.bs2, t, r.opts.runPattern, r.opts.profileRun,
+		)
+		if err != nil {
+			return err
+		}
+		r.mu.Lock()
+		r.units[t] = units
+		r.mu.Unlock()
+	}
+
+	// Interleave test suite runs instead of using -count=itersPerTest. The
+	// idea is that this reduces the chance that we pick up external noise
+	// with a time correlation. When interleaving individual benchmarks,
+	// also randomize their order so that no benchmark is systematically
+	// affected by the ones that run before it. Which ref runs first in
+	// each iteration is determined by the configured order.
+	rng := iterRand(r.opts.seed, t, seed)
+	iterUnits := append([]benchUnit(nil), units...)
+	rng.Shuffle(len(iterUnits), func(a, b int) {
+		iterUnits[a], iterUnits[b] = iterUnits[b], iterUnits[a]
+	})
+	suites := orderSuites(r.opts.order, rng, j, r.bs1, r.bs2)
+	outs := make(map[*benchSuite]*bytes.Buffer)
+	pending := make(pendingProfiles)
+	for _, u := range iterUnits {
+		profile := profiled && r.opts.profiled(u)
+		if profiled && !profile {
+			continue
+		}
+		for _, b := range suites {
+			if !u.runsOn(b) {
+				continue
+			}
+			if outs[b] == nil {
+				outs[b] = new(bytes.Buffer)
+			}
+			progress(" " + b.ref + " " + u.name)
+			if profile {
+				if err := b.unlinkProfiles(t, r.opts.profiles.merged()); err != nil {
+					return err
+				}
+			}
+			passed, err := runSingleBench(ctx, b, t, u, profile, j, cpus, outs[b], r.opts)
 			if err != nil {
 				return err
 			}
-			p, err := profile.Parse(bytes.NewReader(mergedBytes))
-			if err != nil {
+			if !profile {
+				continue
+			}
+			if !passed {
+				// The profiles of a failed invocation are incomplete, if they
+				// were written at all.
+				name := u.name
+				if name == "" {
+					name = b.testPkg(t)
+				}
+				fmt.Fprintf(os.Stderr, "  skipping profiles of %s on %s\n", name, b.ref)
+				continue
+			}
+			bench := ""
+			if u.profile {
+				bench = u.name
+			}
+			if err := b.collectProfiles(pending, r.runTime, t, bench, r.opts.profiles.merged()); err != nil {
 				return err
 			}
-			srcs = append(srcs, p)
 		}
-		{
-			newBytes, err := os.ReadFile(bs.getProfileFile(cur.from))
-			if err != nil {
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if profiled {
+		for b, out := range outs {
+			if err := appendFile(b.getProfileOutputFile(r.runTime), out); err != nil {
 				return err
 			}
-			p, err := profile.Parse(bytes.NewReader(newBytes))
+		}
+		if err := r.ckpt.saveProfiled(r.runTime, t, r.bs1, r.bs2); err != nil {
+			return err
+		}
+		// The profiles are only merged once the iteration is checkpointed,
+		// so that a resumed run never merges those of an iteration twice.
+		return pending.merge()
+	}
+	for b, out := range outs {
+		if _, err := b.outFile.Seek(0, io.SeekEnd); err != nil {
+			return err
+		}
+		if _, err := out.WriteTo(b.outFile); err != nil {
+			return err
+		}
+	}
+	return r.ckpt.save(r.runTime, t, r.bs1, r.bs2)
+}
+
+// appendFile appends the buffer's contents to the file at the provided path,
+// creating it if necessary.
+func appendFile(path string, buf *bytes.Buffer) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := buf.WriteTo(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// iterPolicy decides how many iterations of a test binary to run.
+type iterPolicy struct {
+	r      *benchRunner
+	test   string
+	budget *timeBudget
+	start  time.Time
+	// ci is the widest confidence interval of the package's benchmarks as of
+	// the last check.
+	ci float64
+}
+
+func (r *benchRunner) newIterPolicy(test string, budget *timeBudget) *iterPolicy {
+	return &iterPolicy{r: r, test: test, budget: budget, start: time.Now(), ci: math.Inf(1)}
+}
+
+// more returns whether to run iteration j. Without a time budget, count
+// iterations are run, after which adaptive mode keeps iterating until the
+// package's results are precise enough. With a time budget, the budget's plan
+// determines the number of iterations, which adaptive mode can cut short.
+func (p *iterPolicy) more(j int) (bool, error) {
+	opts := p.r.opts
+	a := opts.adaptive
+	if p.budget != nil {
+		if j >= p.budget.targets[p.test] || !p.budget.fits(p.test) {
+			return false, nil
+		}
+		if j < opts.itersPerTest || !a.enabled() {
+			return true, nil
+		}
+	} else {
+		if j < opts.itersPerTest {
+			return true, nil
+		}
+		if !a.enabled() || j >= a.maxCount {
+			return false, nil
+		}
+	}
+	if a.maxTime > 0 && time.Since(p.start) > a.maxTime {
+		return false, nil
+	}
+	p.r.mu.Lock()
+	ci, ok, err := packageCI(p.r.bs1, p.r.bs2, p.r.bs1.testPkg(p.test), opts.excludeFailed)
+	p.r.mu.Unlock()
+	if err != nil || !ok {
+		return false, err
+	}
+	p.ci = ci
+	return ci > a.ciTarget, nil
+}
+
+// progress formats the progress of iteration j, like "3/10".
+func (p *iterPolicy) progress(j int) string {
+	var s string
+	switch {
+	case p.budget != nil:
+		s = ui.Fraction(j+1, p.budget.targets[p.test])
+	case j < p.r.opts.itersPerTest:
+		s = ui.Fraction(j+1, p.r.opts.itersPerTest)
+	default:
+		s = ui.Fraction(j+1, p.r.opts.adaptive.maxCount)
+	}
+	if !math.IsInf(p.ci, 1) {
+		s += fmt.Sprintf(" ci=±%.1f%%", p.ci*100)
+	}
+	return s
+}
+
+// unlinkProfiles removes the profiles of the last invocation of the test
+// binary, so that an invocation that fails to write its profiles does not leave
+// an earlier invocation's profiles to be merged again.
+func (bs *benchSuite) unlinkProfiles(test string, profTypes []profileType) error {
+	for _, pt := range profTypes {
+		if err := os.Remove(bs.getLastProfileFile(test, pt.name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// pendingProfiles holds the profiles of the invocations of an iteration, keyed
+// by the path of the merged profile that they are to be merged into.
+type pendingProfiles map[string][]*profile.Profile
+
+// collectProfiles reads the profiles of the last invocation of the test binary
+// into the pending profiles of the run that started at the provided time. They
+// are merged into the run's merged profiles, or into the benchmark's merged
+// profiles if the invocation was of a single profiled benchmark.
+func (bs *benchSuite) collectProfiles(
+	pending pendingProfiles, t time.Time, test, bench string, profTypes []profileType,
+) error {
+	for _, pt := range profTypes {
+		dest := bs.getProfileFile(t, pt.name)
+		if bench != "" {
+			dest = bs.getBenchProfileFile(t, test, bench, pt.name)
+		}
+		p, err := readProfile(bs.getLastProfileFile(test, pt.name))
+		if err != nil {
+			return err
+		}
+		pending[dest] = append(pending[dest], p)
+	}
+	return nil
+}
+
+// merge merges the pending profiles into their merged profiles. Each merged
+// profile is replaced atomically, so that an interruption leaves either the
+// old or the new merged profile behind.
+func (pending pendingProfiles) merge() error {
+	for dest, srcs := range pending {
+		if _, err := os.Stat(dest); err == nil {
+			p, err := readProfile(dest)
 			if err != nil {
 				return err
 			}
-			srcs = append(srcs, p)
+			srcs = append([]*profile.Profile{p}, srcs...)
 		}
-
 		merged, err := profile.Merge(srcs)
 		if err != nil {
 			return err
 		}
-		f, err := os.Create(dest)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		f, err := os.Create(dest + ".tmp")
 		if err != nil {
 			return err
 		}
@@ -465,53 +1174,132 @@ func (bs *benchSuite) mergeProfiles(cpuProfile, memProfile, mutexProfile bool) e
 		if err := f.Close(); err != nil {
 			return err
 		}
+		if err := os.Rename(dest+".tmp", dest); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+func readProfile(path string) (*profile.Profile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := profile.Parse(bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing profile %s", path)
+	}
+	return p, nil
+}
+
+// runSingleBench runs the benchmarks of the test binary that match the pattern
+// and writes their output to out. If cpus is not nil, the binary is pinned to
+// the CPU set. Failed, panicked and hung benchmarks are reported in the output
+// and do not return an error, but the invocation is reported as not passed.
 func runSingleBench(
-	bs *benchSuite, test, runPattern, benchTime string, cpuProfile, memProfile, mutexProfile bool,
-) error {
+	ctx context.Context,
+	bs *benchSuite,
+	test string,
+	u benchUnit,
+	profile bool, // record the unit's profiles
+	iter int, // of the profiled iteration
+	cpus *cpuSet,
+	out *bytes.Buffer,
+	opts benchOptions,
+) (passed bool, _ error) {
 	bin := bs.getTestBinary(test)
 
 	// Determine whether the binary has a --logtostderr flag. Use CombinedOutput
 	// and ignore the error because --help creates a failed error status. If there
 	// is a real error we'll hit it below.
-	cmd := exec.Command(bin, "--help")
-	out, _ := cmd.CombinedOutput()
-	hasLogToStderr := bytes.Contains(out, []byte("logtostderr"))
+	cmd := exec.CommandContext(ctx, bin, "--help")
+	help, _ := cmd.CombinedOutput()
+	hasLogToStderr := bytes.Contains(help, []byte("logtostderr"))
 
 	// Run the benchmark binary.
-	args := []string{bin, "-test.run", "-", "-test.bench", runPattern, "-test.benchmem"}
-	if benchTime != "" {
-		args = append(args, "-test.benchtime", benchTime)
-	}
-	if cpuProfile {
-		args = append(args, "-test.cpuprofile", bs.getProfileFile("cpu_last"))
+	args := []string{bin, "-test.run", "-", "-test.bench", u.pattern, "-test.benchmem"}
+	if opts.benchTime != "" {
+		args = append(args, "-test.benchtime", opts.benchTime)
 	}
-	if memProfile {
-		// TODO(nvanbenschoten): consider passing -test.memprofilerate=1.
-		args = append(args, "-test.memprofile", bs.getProfileFile("mem_last"))
-	}
-	if mutexProfile {
-		args = append(args, "-test.mutexprofile", bs.getProfileFile("mutex_last"))
+	if profile {
+		if u.profile {
+			// Profiles that are not merged are written to the benchmark's
+			// directory.
+			if err := os.MkdirAll(filepath.Join(bs.artDir, test, u.name), 0755); err != nil {
+				return false, err
+			}
+		}
+		args = append(args, opts.profiles.args(bs, test, u, iter)...)
 	}
 	if hasLogToStderr {
 		args = append(args, "--logtostderr", "NONE")
 	}
-	if err := spawnWith(os.Stdin, bs.outFile, bs.outFile, args...); err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			if exitErr.ExitCode() == 1 {
-				// Assume exit code 1 corresponds to a benchmark failure.
-				fmt.Fprintln(os.Stderr, "  saw one or more benchmark failures")
-			} else {
-				return errors.Wrapf(err, "error running %v: %s", args, exitErr.Stderr)
+	// Remember where this invocation's output starts so that failures can be
+	// found in it.
+	off := out.Len()
+	wd := newWatchdog(out, opts.benchTimeout, opts.hangTimeout)
+	env := spawnEnv()
+	if cpus != nil {
+		args = cpus.pin(args)
+		env = append(env, fmt.Sprintf("GOMAXPROCS=%d", cpus.size()))
+	}
+	cmd = command(ctx, args...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = wd
+	cmd.Stderr = wd
+	dumpPath := bs.getHangDumpFile(test, time.Now())
+	hung, err := wd.run(cmd, dumpPath)
+	if err != nil && ctx.Err() != nil {
+		return false, err
+	}
+	if hung != "" {
+		// Mark the running benchmark as failed and move on.
+		name, ok := wd.runningBenchmark()
+		if !ok {
+			name, ok = benchmarkFromDump(dumpPath)
+		}
+		if !ok {
+			name = bs.testPkg(test)
+		}
+		fmt.Fprintf(out, "\n--- FAIL: %s\n    benchdiff: %s; goroutine dump written to %s\n",
+			name, hung, dumpPath)
+		fmt.Fprintf(os.Stderr, "  %s %s; goroutine dump written to %s\n", name, hung, dumpPath)
+		return false, nil
+	}
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return false, errors.Wrapf(err, "error running %v", args)
+		}
+		// Test binaries exit with code 1 when a benchmark fails and with code
+		// 2 when one panics. Either way, the failures are reported in the
+		// output and the run continues. Any other exit, or one without a
+		// reported failure, is an error.
+		var fs []benchFailure
+		if code := exitErr.ExitCode(); code == 1 || code == 2 {
+			if fs, err = parseFailures(bytes.NewReader(out.Bytes()[off:])); err != nil {
+				return false, err
 			}
-		} else {
-			return errors.Wrapf(err, "error running %v", args)
 		}
+		if len(fs) == 0 {
+			return false, errors.Wrapf(err, "error running %v", args)
+		}
+		for _, f := range fs {
+			name := f.benchmark
+			if name == "" {
+				name = bs.testPkg(test)
+			}
+			verb := "failed"
+			if f.kind == "panic" {
+				verb = "panicked"
+			}
+			fmt.Fprintf(os.Stderr, "  %s %s on %s\n", name, verb, bs.ref)
+		}
+		return false, nil
 	}
-	return nil
+	return true, nil
 }
 
 func processBenchOutput(
@@ -519,76 +1307,117 @@ func processBenchOutput(
 	w io.Writer,
 	oldSuite, newSuite *benchSuite,
 	byName bool, // instead of by delta reversed
-	out outputFmt,
-	pkgFilter []string,
-	srv *google.Service,
-) ([]*benchstat.Table, error) {
-	// We're going to be reading the output files, so seek to the beginning.
-	oldSuite.outFile.Seek(0, io.SeekStart)
-	newSuite.outFile.Seek(0, io.SeekStart)
-
-	// Compute the benchmark comparison results.
-	var c benchstat.Collection
-	c.Alpha = 0.05
+	byPkg bool, // group the benchmarks of each package
+	excludeFailed bool, // drop the results of failed benchmarks
+	out exporter,
+	md runMetadata,
+) (*report, string, error) {
+	var splitBy []string
+	if byPkg {
+		splitBy = []string{"pkg"}
+	}
+	c, failures, err := collectBenchOutput(oldSuite, newSuite, excludeFailed, splitBy)
+	if err != nil {
+		return nil, "", err
+	}
 	if byName {
 		c.Order = benchstat.ByName
 	} else {
 		c.Order = benchstat.Reverse(benchstat.ByDelta) // best, first
 	}
-	if err := c.AddFile("old", oldSuite.outFile); err != nil {
-		return nil, err
+	r := &report{md: md, tables: c.Tables(), failures: failures}
+
+	// Generate differential profiles. Failing to do so is not fatal, as the
+	// comparison can still be output.
+	r.profileDiffs, err = diffProfiles(oldSuite, newSuite, md)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
 	}
-	if err := c.AddFile("new", newSuite.outFile); err != nil {
-		return nil, err
+	pairs, err := listProfilePairs(oldSuite, newSuite, md)
+	if err == nil {
+		r.culprits, err = attributeRegressions(r.tables, pairs)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
 	}
-	tables := c.Tables()
 
 	// Output the results.
-	switch out {
-	case text:
-		benchstat.FormatText(w, tables)
-	case csv:
-		// If norange is true, suppress the range information for each data item.
-		// If norange is false, insert a "±" in the appropriate columns of the header row.
-		norange := false
-		benchstat.FormatCSV(w, tables, norange)
-	case html:
-		var buf bytes.Buffer
-		benchstat.FormatHTML(&buf, tables)
-		io.Copy(w, &buf)
-	case sheets:
-		// When outputting a Google sheet, also output as text first.
-		benchstat.FormatText(w, tables)
-
-		sheetName := fmt.Sprintf("benchdiff: %s (%s -> %s)",
-			strings.Join(pkgFilter, " "), oldSuite.ref, newSuite.ref)
-		url, err := srv.CreateSheet(ctx, sheetName, tables)
+	link, err := out.export(ctx, w, r)
+	if err != nil {
+		return nil, "", err
+	}
+	return r, link, nil
+}
+
+// collectBenchOutput reads the benchmark output of both suites into a
+// benchstat collection, split by the provided labels, and finds the benchmark
+// failures of each.
+func collectBenchOutput(
+	oldSuite, newSuite *benchSuite, excludeFailed bool, splitBy []string,
+) (*benchstat.Collection, []failureSummary, error) {
+	// Find the benchmark failures of each ref. We're going to be reading the
+	// output files, so seek to the beginning.
+	var fss [2][]benchFailure
+	for i, bs := range []*benchSuite{oldSuite, newSuite} {
+		if _, err := bs.outFile.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+		fs, err := parseFailures(bs.outFile)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		fss[i] = fs
+	}
+	failures := summarizeFailures(fss[0], fss[1])
+
+	// Compute the benchmark comparison results.
+	c := &benchstat.Collection{Alpha: 0.05, SplitBy: splitBy}
+	for i, bs := range []*benchSuite{oldSuite, newSuite} {
+		if _, err := bs.outFile.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+		var in io.Reader = bs.outFile
+		if excludeFailed && len(failures) > 0 {
+			var err error
+			if in, err = excludeFailures(in, failures); err != nil {
+				return nil, nil, err
+			}
+		}
+		if err := c.AddFile([]string{"old", "new"}[i], in); err != nil {
+			return nil, nil, err
 		}
-		fmt.Printf("\ngenerated sheet: %s\n", url)
-	default:
-		panic("unexpected")
 	}
-	return tables, nil
+	return c, failures, nil
 }
 
-func logProfileLocations(
-	bs1, bs2 *benchSuite, cpuProfile, memProfile, mutexProfile bool,
-) {
-	log := func(profType string) {
-		fmt.Printf("\nwrote merged %s profile to:\n  old=%s\n  new=%s\n",
-			profType, bs1.getProfileFile(profType), bs2.getProfileFile(profType))
+// packageCI returns the widest relative confidence interval of the package's
+// benchmarks, as determined by the benchmark output collected so far.
+func packageCI(oldSuite, newSuite *benchSuite, pkg string, excludeFailed bool) (float64, bool, error) {
+	c, _, err := collectBenchOutput(oldSuite, newSuite, excludeFailed, []string{"pkg"})
+	if err != nil {
+		return 0, false, err
 	}
-	if cpuProfile {
-		log("cpu")
+	// Computing the tables computes the statistics of each metric.
+	_ = c.Tables()
+	ci, ok := widestCI(c, pkg)
+	return ci, ok, nil
+}
+
+func logProfileLocations(bs1, bs2 *benchSuite, md runMetadata) error {
+	pairs, err := listProfilePairs(bs1, bs2, md)
+	if err != nil {
+		return err
 	}
-	if memProfile {
-		log("mem")
+	for _, p := range pairs {
+		fmt.Printf("\nwrote merged %s profile to:\n  old=%s\n  new=%s\n", p.name(), p.old, p.new)
 	}
-	if mutexProfile {
-		log("mutex")
+	for _, name := range md.Profiles {
+		if pt, ok := lookupProfileType(name); ok && !pt.merged {
+			fmt.Printf("\nwrote %s files of each iteration to:\n  old=%s\n  new=%s\n",
+				name, bs1.artDir, bs2.artDir)
+		}
 	}
+	return nil
 }
 
 func checkPassing(thresh float64, tables []*benchstat.Table) error {
@@ -615,21 +1444,26 @@ type benchSuite struct {
 	artDir    string
 	outFile   *os.File
 	binDir    string
-	useBazel  bool
+	bazel     *bazelOptions // if set, the test binaries are built with Bazel
 	testFiles fileSet
+	// bins records the sources of the test binaries. It is nil for binary
+	// directories that predate binary manifests.
+	bins binManifest
 }
 type fileSet map[string]struct{}
 
-func makeBenchSuite(ref string, subject string, useBazel bool) benchSuite {
+func makeBenchSuite(ref string, subject string, bazel *bazelOptions) benchSuite {
 	return benchSuite{
 		ref:       ref,
 		subject:   subject,
 		testFiles: make(fileSet),
-		useBazel:  useBazel,
+		bazel:     bazel,
 	}
 }
 
-func (bs *benchSuite) build(pkgFilter []string, postChck string, t time.Time) (err error) {
+func (bs *benchSuite) build(
+	ctx context.Context, pkgFilter []string, runPattern, postChck string, t time.Time,
+) (err error) {
 	if len(bs.testFiles) != 0 {
 		panic("benchSuite already built")
 	}
@@ -647,8 +1481,8 @@ func (bs *benchSuite) build(pkgFilter []string, postChck string, t time.Time) (e
 		return err
 	}
 
-	// Create the binary directory: ./benchdiff/<ref>/bin/<hash(pkgFilter)>
-	bs.binDir = testBinDir(bs.ref, pkgFilter)
+	// Create the binary directory: ./benchdiff/<ref>/bin/<hash(naming, pkgFilter, runPattern, bazel)>
+	bs.binDir = testBinDir(bs.ref, pkgFilter, runPattern, bs.bazel)
 	if _, err = os.Stat(bs.binDir); err == nil {
 		files, err := ioutil.ReadDir(bs.binDir)
 		if err != nil {
@@ -661,9 +1495,13 @@ func (bs *benchSuite) build(pkgFilter []string, postChck string, t time.Time) (e
 				}
 				continue
 			}
+			if f.Name() == binManifestFile {
+				continue
+			}
 			bs.testFiles[f.Name()] = struct{}{}
 		}
-		return nil
+		bs.bins, err = readBinManifest(bs.binDir)
+		return err
 	} else if !os.IsNotExist(err) {
 		return errors.Wrap(err, "looking for test directory")
 	}
@@ -678,35 +1516,54 @@ func (bs *benchSuite) build(pkgFilter []string, postChck string, t time.Time) (e
 		}
 	}()
 
-	if err := checkoutRef(bs.ref, postChck); err != nil {
+	if err := checkoutRef(ctx, bs.ref, postChck); err != nil {
 		return err
 	}
 
 	// Determine which packages to build.
-	pkgs, err := expandPackages(pkgFilter)
+	pkgs, err := expandPackages(ctx, pkgFilter)
 	if err != nil {
 		return err
 	}
+	numPkgs := len(pkgs)
+	if pkgs, err = filterBenchPackages(pkgs, runPattern); err != nil {
+		return err
+	}
+	if skipped := numPkgs - len(pkgs); skipped > 0 {
+		fmt.Fprintf(os.Stderr, "skipping %d of %d packages without benchmarks matching %q for %s\n",
+			skipped, numPkgs, runPattern, bs.ref)
+	}
 
 	w := ui.NewWriter(os.Stderr)
 	spinner := ui.StartSpinner(w, fmt.Sprintf(
-		"building benchmark binaries for %s: %.50s [bazel=%t] ", bs.ref, bs.subject, bs.useBazel,
+		"building benchmark binaries for %s: %.50s [bazel=%t] ", bs.ref, bs.subject, bs.bazel != nil,
 	))
 	defer spinner.Stop()
-	buildTestBin := buildTestBinWithGo
-	if bs.useBazel {
-		buildTestBin = buildTestBinWithBazel
-	}
-	for i, pkg := range pkgs {
-		spinner.Update(ui.Fraction(i, len(pkgs)))
-		if testBin, ok, err := buildTestBin(pkg, bs.binDir); err != nil {
+	if bs.bazel != nil {
+		bs.bins, err = buildTestBinsWithBazel(ctx, pkgs, bs.binDir, bs.bazel, func(done, total int) {
+			spinner.Update(ui.Fraction(done, total))
+		})
+		if err != nil {
 			return err
-		} else if ok {
-			bs.testFiles[testBin] = struct{}{}
 		}
-		spinner.Update(ui.Fraction(i+1, len(pkgs)))
+	} else {
+		bs.bins = make(binManifest)
+		for i, pkg := range pkgs {
+			spinner.Update(ui.Fraction(i, len(pkgs)))
+			if testBin, ok, err := buildTestBinWithGo(ctx, pkg, bs.binDir); err != nil {
+				return err
+			} else if ok {
+				bs.bins[testBin] = testBinInfo{Pkg: pkg.ImportPath}
+			}
+			spinner.Update(ui.Fraction(i+1, len(pkgs)))
+		}
 	}
-	return nil
+	for bin, info := range bs.bins {
+		info.Ref = bs.ref
+		bs.bins[bin] = info
+		bs.testFiles[bin] = struct{}{}
+	}
+	return writeBinManifest(bs.binDir, bs.bins)
 }
 
 func (bs *benchSuite) close() {
@@ -717,8 +1574,54 @@ func (bs *benchSuite) getOutputFile(t time.Time) string {
 	return filepath.Join(bs.artDir, "out."+t.Format(timeFormat))
 }
 
-func (bs *benchSuite) getProfileFile(profType string) string {
-	return filepath.Join(bs.artDir, profType+".prof")
+// getProfileOutputFile returns the path of the benchmark output of the profiled
+// iterations of the run, which is not part of the comparison.
+func (bs *benchSuite) getProfileOutputFile(t time.Time) string {
+	return filepath.Join(bs.artDir, "profiled."+t.Format(timeFormat))
+}
+
+func (bs *benchSuite) getManifestFile(t time.Time) string {
+	return filepath.Join(bs.artDir, "run."+t.Format(timeFormat)+".json")
+}
+
+func (bs *benchSuite) getHangDumpFile(test string, t time.Time) string {
+	return filepath.Join(bs.artDir, "hang."+test+"."+t.Format(timeFormat)+".txt")
+}
+
+func (bs *benchSuite) getCheckpointFile(t time.Time) string {
+	return filepath.Join(bs.artDir, "checkpoint."+t.Format(timeFormat)+".json")
+}
+
+// getProfileDir returns the directory of the merged profiles of the run, so
+// that runs of the same refs do not share profiles.
+func (bs *benchSuite) getProfileDir(t time.Time) string {
+	return filepath.Join(bs.artDir, "profiles."+t.Format(timeFormat))
+}
+
+// getProfileFile returns the path of the merged profile of all profiled
+// benchmarks of the run.
+func (bs *benchSuite) getProfileFile(t time.Time, profType string) string {
+	return filepath.Join(bs.getProfileDir(t), profType+".prof")
+}
+
+// getBenchProfileFile returns the path of the merged profile of a benchmark
+// of the run that was profiled on its own with --profile-run.
+func (bs *benchSuite) getBenchProfileFile(t time.Time, test, bench, profType string) string {
+	return filepath.Join(bs.getProfileDir(t), test, bench, profType+".prof")
+}
+
+// getLastProfileFile returns the path of the profile of the last invocation of
+// the test binary, which is merged into the run's profiles.
+func (bs *benchSuite) getLastProfileFile(test, profType string) string {
+	return filepath.Join(bs.artDir, profType+"_last."+test+".prof")
+}
+
+// testPkg returns the import path of the package of the test binary.
+func (bs *benchSuite) testPkg(bin string) string {
+	if info, ok := bs.bins[bin]; ok {
+		return info.Pkg
+	}
+	return testBinToPkg(bin)
 }
 
 func (bs *benchSuite) getTestBinary(bin string) string {
@@ -744,9 +1647,17 @@ func (fs fileSet) sorted() []string {
 	return s
 }
 
-func printHeader(w io.Writer, oldSuite, newSuite benchSuite) {
+func printHeader(
+	w io.Writer, oldSuite, newSuite benchSuite, host *hostInfo, runOrder string, seed int64,
+) {
 	fmt.Fprintf(w, "old:  %s %.50s\n", oldSuite.ref, oldSuite.subject)
 	fmt.Fprintf(w, "new:  %s %.50s\n", newSuite.ref, newSuite.subject)
+	if host != nil {
+		fmt.Fprintf(w, "host: %s\n", host.summary())
+	}
+	if runOrder != "" {
+		fmt.Fprintf(w, "order: %s (seed=%d)\n", runOrder, seed)
+	}
 	fmt.Fprintf(w, "args: %s\n\n", strings.Join(func() []string {
 		quoted := make([]string, 1+len(os.Args[1:]))
 		quoted[0] = "benchdiff"
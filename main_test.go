@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// fakeTestBinary is a test binary that copies a fixed CPU profile to the path
+// passed to -test.cpuprofile and prints a benchmark result. Writing "fail",
+// "panic" or "crash" to its mode file makes a benchmark fail after writing the
+// profile, panic, or exit without reporting a failure instead.
+const fakeTestBinary = `#!/bin/sh
+if [ "$1" = --help ]; then exit 0; fi
+prof=
+prev=
+for a in "$@"; do
+	if [ "$prev" = -test.cpuprofile ]; then prof=$a; fi
+	prev=$a
+done
+case "$(cat "$0.mode" 2>/dev/null)" in
+panic)
+	echo "panic: boom"
+	exit 2;;
+crash)
+	exit 3;;
+esac
+if [ -n "$prof" ]; then cp '%s' "$prof"; fi
+if [ "$(cat "$0.mode" 2>/dev/null)" = fail ]; then
+	echo "--- FAIL: BenchmarkFoo"
+	echo "    foo_test.go:12: boom"
+	exit 1
+fi
+echo "BenchmarkFoo-8   	       1	       100 ns/op"
+`
+
+// fakeProfileTotal is the sum of the sample values of the fake test binary's
+// CPU profile.
+const fakeProfileTotal = 2e9
+
+// newFakeBenchRunner returns a runner of profiled iterations of a fake test
+// binary named "pkg" on an old and a new benchmark suite.
+func newFakeBenchRunner(t *testing.T, dir string, runTime time.Time, ckpt *runCheckpoint) *benchRunner {
+	t.Helper()
+	fixture := filepath.Join(dir, "fixture.prof")
+	if !fileExists(fixture) {
+		writeTestProfile(t, fixture, fakeProfileTotal/2, fakeProfileTotal/2)
+	}
+	var suites []*benchSuite
+	for _, ref := range []string{"old", "new"} {
+		bs := &benchSuite{
+			ref:    ref,
+			artDir: filepath.Join(dir, ref, "artifacts"),
+			binDir: filepath.Join(dir, ref, "bin"),
+		}
+		for _, d := range []string{bs.artDir, bs.binDir} {
+			if err := os.MkdirAll(d, 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		bin := fmt.Sprintf(fakeTestBinary, fixture)
+		if err := os.WriteFile(bs.getTestBinary("pkg"), []byte(bin), 0755); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		bs.outFile, err = os.OpenFile(bs.getOutputFile(runTime), os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(bs.close)
+		suites = append(suites, bs)
+	}
+	cpu, _ := lookupProfileType("cpu")
+	opts := benchOptions{
+		profiles:     profileOptions{types: []profileType{cpu}},
+		profileCount: 3,
+		interleave:   interleavePackage,
+		order:        orderFixed,
+	}
+	return newBenchRunner(suites[0], suites[1], opts, runTime, ckpt)
+}
+
+// setFakeMode sets the mode of the suite's fake test binary.
+func setFakeMode(t *testing.T, bs *benchSuite, mode string) {
+	t.Helper()
+	if err := os.WriteFile(bs.getTestBinary("pkg")+".mode", []byte(mode), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// profileTotal returns the sum of the sample values of the profile.
+func profileTotal(t *testing.T, path string) float64 {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, err := profile.Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, s := range p.Sample {
+		total += s.Value[0]
+	}
+	return float64(total)
+}
+
+func TestProfiledIterationFailure(t *testing.T) {
+	ctx := context.Background()
+	runTime := time.Date(2021, 7, 20, 18, 47, 32, 0, time.UTC)
+	progress := func(string) {}
+
+	// The new ref fails in the second profiled iteration. The run goes on
+	// without merging its profiles, and the profile of its first iteration is
+	// not merged again in their place.
+	for _, failMode := range []string{"fail", "panic"} {
+		t.Run(failMode, func(t *testing.T) {
+			ckpt := makeRunCheckpoint()
+			r := newFakeBenchRunner(t, t.TempDir(), runTime, &ckpt)
+			for i, mode := range []string{"", failMode, ""} {
+				setFakeMode(t, r.bs2, mode)
+				if err := r.runProfileIteration(ctx, "pkg", nil /* cpus */, progress); err != nil {
+					t.Fatalf("iteration %d: %v", i, err)
+				}
+			}
+			if n := ckpt.Profiled["pkg"]; n != 3 {
+				t.Errorf("expected 3 profiled iterations, found %d", n)
+			}
+			for _, tc := range []struct {
+				bs    *benchSuite
+				iters int
+			}{
+				{r.bs1, 3},
+				{r.bs2, 2},
+			} {
+				exp := float64(tc.iters) * fakeProfileTotal
+				if total := profileTotal(t, tc.bs.getProfileFile(runTime, "cpu")); total != exp {
+					t.Errorf("%s: expected the samples of %d iterations (%g), found %g", tc.bs.ref, tc.iters, exp, total)
+				}
+			}
+		})
+	}
+}
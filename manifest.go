@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// runMetadata describes a benchmark run. It is handed to exporters alongside
+// the run's results and is persisted as the run's manifest in the artifacts
+// directory of both the old and the new ref, next to the run's output files.
+type runMetadata struct {
+	Time       time.Time `json:"time"` // when the run started
+	OldRef     string    `json:"old_ref"`
+	NewRef     string    `json:"new_ref"`
+	OldSubject string    `json:"old_subject"`
+	NewSubject string    `json:"new_subject"`
+	PkgFilter  []string  `json:"pkg_filter"`
+	Args       []string  `json:"args"`
+	Bazel      bool      `json:"bazel"`
+	RunPattern string    `json:"run_pattern"`
+	BenchTime  string    `json:"bench_time"`
+	Count      int       `json:"count"`
+	Interleave string    `json:"interleave,omitempty"`
+	Order      string    `json:"order,omitempty"`
+	Seed       int64     `json:"seed,omitempty"`
+	Profiles   []string  `json:"profiles,omitempty"`
+	ProfileRun string    `json:"profile_run,omitempty"`
+	// MemRate and BlockRate are the memory and block profile rates, if set.
+	MemRate   int `json:"mem_profile_rate,omitempty"`
+	BlockRate int `json:"block_profile_rate,omitempty"`
+	// ProfileCount is the number of profiled iterations of each package.
+	ProfileCount int `json:"profile_count,omitempty"`
+	// CITarget, MaxCount and MaxTime configure adaptive iteration counts. If
+	// CITarget is zero, Count iterations were run for each package.
+	CITarget float64       `json:"ci_target,omitempty"`
+	MaxCount int           `json:"max_count,omitempty"`
+	MaxTime  time.Duration `json:"max_time,omitempty"`
+	// TimeBudget, if non-zero, limited the duration of the run.
+	TimeBudget time.Duration `json:"time_budget,omitempty"`
+	// Parallel is the number of test binaries that ran at a time. If greater
+	// than one, CPUSets maps each package to the CPU list it was pinned to.
+	Parallel int               `json:"parallel,omitempty"`
+	CPUSets  map[string]string `json:"cpu_sets,omitempty"`
+	// BazelConfigs and BazelStartupFlags are the --config values and the
+	// startup flags that were passed to bazel, if Bazel is set.
+	BazelConfigs      []string `json:"bazel_configs,omitempty"`
+	BazelStartupFlags []string `json:"bazel_startup_flags,omitempty"`
+	// Host describes the machine and toolchain that the run was performed
+	// with.
+	Host *hostInfo `json:"host,omitempty"`
+	// Env describes the conditions on the machine during the run.
+	Env *envReport `json:"env,omitempty"`
+}
+
+// writeManifest writes the run's manifest to the artifacts directory of each
+// of the provided benchmark suites.
+func writeManifest(md runMetadata, bss ...*benchSuite) error {
+	b, err := json.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return err
+	}
+	for _, bs := range bss {
+		if err := os.WriteFile(bs.getManifestFile(md.Time), b, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readManifest reads the run manifest at the provided path.
+func readManifest(path string) (runMetadata, error) {
+	var md runMetadata
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return md, err
+	}
+	err = json.Unmarshal(b, &md)
+	return md, err
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Execution orders, which determine the order in which packages are run and
+// in which the old and new refs are run in each iteration.
+const (
+	// orderFixed runs packages in sorted order and the old ref before the new
+	// ref in every iteration.
+	orderFixed = "fixed"
+	// orderAlternate runs packages in sorted order and alternates between
+	// running the old and the new ref first in each iteration.
+	orderAlternate = "alternate"
+	// orderRandom runs packages in a random order and randomly picks which ref
+	// runs first in each iteration.
+	orderRandom = "random"
+)
+
+func checkOrder(order string) error {
+	switch order {
+	case orderFixed, orderAlternate, orderRandom:
+		return nil
+	default:
+		return errors.Errorf("unknown order %q; expected %q, %q or %q",
+			order, orderFixed, orderAlternate, orderRandom)
+	}
+}
+
+// orderTests returns the order in which to run the test binaries.
+func orderTests(tests []string, order string, seed int64) []string {
+	res := append([]string(nil), tests...)
+	sort.Strings(res)
+	if order == orderRandom {
+		rng := rand.New(rand.NewSource(seed))
+		rng.Shuffle(len(res), func(i, j int) { res[i], res[j] = res[j], res[i] })
+	}
+	return res
+}
+
+// iterRand returns the source of randomness for an iteration of a test binary.
+// It depends only on the seed, the test binary, and the iteration, so that a
+// resumed run makes the same choices as the run that it resumes and a run can
+// be reproduced exactly by passing the same seed.
+func iterRand(seed int64, test string, iter int) *rand.Rand {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(test))
+	return rand.New(rand.NewSource(seed ^ int64(h.Sum64()) + int64(iter)))
+}
+
+// orderSuites returns the order in which to run the benchmark suites in an
+// iteration.
+func orderSuites(order string, rng *rand.Rand, iter int, bs1, bs2 *benchSuite) []*benchSuite {
+	swap := false
+	switch order {
+	case orderAlternate:
+		swap = iter%2 == 1
+	case orderRandom:
+		swap = rng.Intn(2) == 1
+	}
+	if swap {
+		return []*benchSuite{bs2, bs1}
+	}
+	return []*benchSuite{bs1, bs2}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOrderTests(t *testing.T) {
+	tests := []string{"c", "a", "d", "b"}
+	sorted := []string{"a", "b", "c", "d"}
+	if res := orderTests(tests, orderFixed, 1); !reflect.DeepEqual(res, sorted) {
+		t.Errorf("expected %v, found %v", sorted, res)
+	}
+	if res := orderTests(tests, orderAlternate, 1); !reflect.DeepEqual(res, sorted) {
+		t.Errorf("expected %v, found %v", sorted, res)
+	}
+	// The random order is determined by the seed.
+	res1 := orderTests(tests, orderRandom, 1)
+	res2 := orderTests(tests, orderRandom, 1)
+	if !reflect.DeepEqual(res1, res2) {
+		t.Errorf("expected the same order for the same seed, found %v and %v", res1, res2)
+	}
+}
+
+func TestOrderSuites(t *testing.T) {
+	bs1, bs2 := &benchSuite{ref: "old"}, &benchSuite{ref: "new"}
+	for j := 0; j < 4; j++ {
+		if s := orderSuites(orderFixed, iterRand(1, "a", j), j, bs1, bs2); s[0] != bs1 {
+			t.Errorf("fixed: expected old first in iteration %d", j)
+		}
+		exp := bs1
+		if j%2 == 1 {
+			exp = bs2
+		}
+		if s := orderSuites(orderAlternate, iterRand(1, "a", j), j, bs1, bs2); s[0] != exp {
+			t.Errorf("alternate: expected %s first in iteration %d", exp.ref, j)
+		}
+	}
+	// The random order of an iteration is the same when it is retried, e.g.
+	// when a run is resumed.
+	for j := 0; j < 16; j++ {
+		s1 := orderSuites(orderRandom, iterRand(7, "a", j), j, bs1, bs2)
+		s2 := orderSuites(orderRandom, iterRand(7, "a", j), j, bs1, bs2)
+		if s1[0] != s2[0] {
+			t.Errorf("random: expected the same order when iteration %d is retried", j)
+		}
+	}
+}
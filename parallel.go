@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nvanbenschoten/benchdiff/ui"
+	"github.com/pkg/errors"
+)
+
+// cpuSet is a set of CPUs that a test binary is pinned to.
+type cpuSet []int
+
+func (c cpuSet) size() int {
+	return len(c)
+}
+
+// String formats the CPU set as a CPU list, like "0-3,8".
+func (c cpuSet) String() string {
+	var b strings.Builder
+	for i := 0; i < len(c); {
+		j := i
+		for j+1 < len(c) && c[j+1] == c[j]+1 {
+			j++
+		}
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.Itoa(c[i]))
+		if j > i {
+			b.WriteString("-" + strconv.Itoa(c[j]))
+		}
+		i = j + 1
+	}
+	return b.String()
+}
+
+// pin prefixes the command's arguments so that it runs on the CPU set.
+func (c cpuSet) pin(args []string) []string {
+	return append([]string{"taskset", "--cpu-list", c.String()}, args...)
+}
+
+// parseCPUList parses a CPU list, like "0-3,8".
+func parseCPUList(s string) (cpuSet, error) {
+	var c cpuSet
+	for _, r := range strings.Split(strings.TrimSpace(s), ",") {
+		lo, hi := r, r
+		if i := strings.IndexByte(r, '-'); i >= 0 {
+			lo, hi = r[:i], r[i+1:]
+		}
+		l, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing CPU list %q", s)
+		}
+		h, err := strconv.Atoi(hi)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing CPU list %q", s)
+		}
+		for cpu := l; cpu <= h; cpu++ {
+			c = append(c, cpu)
+		}
+	}
+	return c, nil
+}
+
+// allowedCPUs returns the CPUs that benchdiff is allowed to run on.
+func allowedCPUs(ctx context.Context) (cpuSet, error) {
+	out, err := capture(ctx, "taskset", "--cpu-list", "--pid", strconv.Itoa(os.Getpid()))
+	if err != nil {
+		return nil, errors.Wrap(err, "determining CPU affinity; --parallel requires taskset")
+	}
+	// The output looks like "pid 123's current affinity list: 0-3,8".
+	i := strings.LastIndexByte(out, ':')
+	if i < 0 {
+		return nil, errors.Errorf("unexpected taskset output %q", out)
+	}
+	return parseCPUList(out[i+1:])
+}
+
+// splitCPUs splits the CPUs into n disjoint CPU sets of equal size. Leftover
+// CPUs are not used.
+func splitCPUs(cpus cpuSet, n int) ([]cpuSet, error) {
+	if n > len(cpus) {
+		return nil, errors.Errorf("cannot run %d test binaries in parallel on %d CPUs", n, len(cpus))
+	}
+	size := len(cpus) / n
+	sets := make([]cpuSet, n)
+	for i := range sets {
+		sets[i] = cpus[i*size : (i+1)*size]
+	}
+	return sets, nil
+}
+
+// makeCPUSets splits the CPUs that benchdiff is allowed to run on into n
+// disjoint CPU sets.
+func makeCPUSets(ctx context.Context, n int) ([]cpuSet, error) {
+	cpus, err := allowedCPUs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return splitCPUs(cpus, n)
+}
+
+// runCmpBenchesParallel is like runCmpBenches, but runs up to len(sets) test
+// binaries concurrently, each pinned to one of the CPU sets with GOMAXPROCS
+// set to match. All iterations of a test binary, on both refs, run on the same
+// CPU set. It returns the CPU set that each package ran on.
+func runCmpBenchesParallel(
+	ctx context.Context,
+	bs1, bs2 *benchSuite,
+	tests []string,
+	opts benchOptions,
+	runTime time.Time,
+	ckpt *runCheckpoint,
+	sets []cpuSet,
+) (map[string]string, error) {
+	r := newBenchRunner(bs1, bs2, opts, runTime, ckpt)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Each worker owns a CPU set and runs one test binary at a time from the
+	// queue.
+	queue := make(chan string, len(tests))
+	for _, t := range tests {
+		queue <- t
+	}
+	close(queue)
+
+	var mu sync.Mutex
+	assigned := make(map[string]string)
+	running := make(map[int]string) // worker -> status
+	done := 0
+	status := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		var b strings.Builder
+		fmt.Fprintf(&b, " pkgs=%s", ui.Fraction(done, len(tests)))
+		for i := range sets {
+			if s, ok := running[i]; ok {
+				fmt.Fprintf(&b, "\n  cpus=%s %s", sets[i], s)
+			}
+		}
+		return b.String()
+	}
+
+	w := ui.NewWriter(os.Stderr)
+	m := w.GetMark()
+	defer w.ClearToMark(m)
+	spinner := ui.StartSpinner(w, fmt.Sprintf("running benchmarks on %d CPU sets:", len(sets)))
+	defer spinner.Stop()
+	update := func() { spinner.Update(status()) }
+
+	errs := make(chan error, len(sets))
+	var wg sync.WaitGroup
+	for i := range sets {
+		i, cpus := i, sets[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- func() error {
+				for t := range queue {
+					pkg := bs1.testPkg(t)
+					mu.Lock()
+					assigned[pkg] = cpus.String()
+					mu.Unlock()
+
+					p := r.newIterPolicy(t, nil /* budget */)
+					// Skip the iterations that were completed before the run
+					// was resumed.
+					for j := r.completed(t); ; j++ {
+						if ok, err := p.more(j); err != nil {
+							return err
+						} else if !ok {
+							break
+						}
+						progress := func(s string) {
+							mu.Lock()
+							running[i] = fmt.Sprintf("iter=%s %s%s", p.progress(j), pkg, s)
+							mu.Unlock()
+							update()
+						}
+						if err := r.runIteration(ctx, t, &cpus, progress); err != nil {
+							return err
+						}
+					}
+					// Profile the package in iterations of its own so that
+					// profiling doesn't skew the timings.
+					for k := r.profiledCompleted(t); k < r.profileIterations(); k++ {
+						progress := func(s string) {
+							mu.Lock()
+							running[i] = fmt.Sprintf("profile=%s %s%s",
+								ui.Fraction(k+1, r.profileIterations()), pkg, s)
+							mu.Unlock()
+							update()
+						}
+						if err := r.runProfileIteration(ctx, t, &cpus, progress); err != nil {
+							return err
+						}
+					}
+
+					mu.Lock()
+					delete(running, i)
+					done++
+					mu.Unlock()
+					update()
+				}
+				return nil
+			}()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(errs)
+	}()
+
+	// Return the first error, stopping the other workers.
+	var err error
+	for e := range errs {
+		if e != nil && err == nil {
+			err = e
+			cancel()
+		}
+	}
+	return assigned, err
+}
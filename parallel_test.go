@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCPUSet(t *testing.T) {
+	for _, tc := range []struct {
+		list string
+		cpus cpuSet
+	}{
+		{"0", cpuSet{0}},
+		{"0-3", cpuSet{0, 1, 2, 3}},
+		{"0-3,8", cpuSet{0, 1, 2, 3, 8}},
+		{"1,3,5-6", cpuSet{1, 3, 5, 6}},
+	} {
+		cpus, err := parseCPUList(tc.list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(cpus, tc.cpus) {
+			t.Errorf("%q: expected %v, found %v", tc.list, tc.cpus, cpus)
+		}
+		if s := cpus.String(); s != tc.list {
+			t.Errorf("expected %q, found %q", tc.list, s)
+		}
+	}
+	if _, err := parseCPUList("0-x"); err == nil {
+		t.Errorf("expected error parsing invalid CPU list")
+	}
+}
+
+func TestSplitCPUs(t *testing.T) {
+	cpus := cpuSet{0, 1, 2, 3, 4, 5, 6, 7, 8}
+	sets, err := splitCPUs(cpus, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []cpuSet{{0, 1, 2, 3}, {4, 5, 6, 7}}
+	if !reflect.DeepEqual(sets, exp) {
+		t.Errorf("expected %v, found %v", exp, sets)
+	}
+	if _, err := splitCPUs(cpus, 10); err == nil {
+		t.Errorf("expected error splitting 9 CPUs into 10 sets")
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/pprof/driver"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+const pprofUsage = `usage: benchdiff pprof [--previous-run=<run>] [--type=<type>] [--pkg=<pkg>] [--bench=<benchmark>]`
+
+const pprofHelpString = `benchdiff pprof opens the pprof web UI on the differential profile of a
+previous run, with the old ref's profile as the base. The test binaries of both
+refs are located in their bin directories, so that the UI can show source and
+disassembly.
+
+By default, the profile of all profiled benchmarks is shown. The profiles of a
+benchmark that was profiled on its own with --profile-run are selected with
+--pkg and --bench.
+
+Options:
+  -p, --previous-run <run>  time, index (see 'benchdiff runs'), or 'latest' of the run (default latest)
+      --type      <type>    profile type: cpu, mem, mutex or block (default: the first recorded)
+      --pkg       <pkg>     package of the benchmark profiled with --profile-run
+      --bench     <name>    benchmark profiled with --profile-run
+      --http      <addr>    host:port to serve the web UI on (default: a free port on localhost)
+      --help                display this help`
+
+func runPProfCmd(ctx context.Context, args []string) error {
+	var help bool
+	var previousRun, profType, pkg, bench, addr string
+	flags := pflag.NewFlagSet("pprof", pflag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, pprofUsage) }
+	flags.BoolVarP(&help, "help", "h", false, "")
+	flags.StringVarP(&previousRun, "previous-run", "p", "latest", "")
+	flags.StringVarP(&profType, "type", "", "", "")
+	flags.StringVarP(&pkg, "pkg", "", "", "")
+	flags.StringVarP(&bench, "bench", "", "", "")
+	flags.StringVarP(&addr, "http", "", "localhost:", "")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if help || flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, pprofUsage)
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, pprofHelpString)
+		return nil
+	}
+
+	prev, err := findPrevRun(ctx, previousRun)
+	if err != nil {
+		return err
+	}
+	md := prev.md
+	if !prev.hasManifest {
+		return errors.Errorf("run %s predates run manifests; its test binaries cannot be located",
+			md.Time.Format(timeFormat))
+	}
+	if profType == "" {
+		for _, name := range md.Profiles {
+			if pt, ok := lookupProfileType(name); ok && pt.merged {
+				profType = name
+				break
+			}
+		}
+		if profType == "" {
+			return errors.Errorf("run %s recorded no profiles", md.Time.Format(timeFormat))
+		}
+	} else if pt, ok := lookupProfileType(profType); !ok || !pt.merged {
+		return errors.Errorf("no differential profiles of type %q; the types are cpu, mem, mutex and block", profType)
+	}
+
+	var bazel *bazelOptions
+	if md.Bazel {
+		bazel = &bazelOptions{configs: md.BazelConfigs, startupFlags: md.BazelStartupFlags}
+	}
+	oldSuite := &benchSuite{
+		ref:    md.OldRef,
+		artDir: testArtifactsDir(md.OldRef),
+		binDir: testBinDir(md.OldRef, md.PkgFilter, md.RunPattern, bazel),
+	}
+	newSuite := &benchSuite{
+		ref:    md.NewRef,
+		artDir: testArtifactsDir(md.NewRef),
+		binDir: testBinDir(md.NewRef, md.PkgFilter, md.RunPattern, bazel),
+	}
+	if newSuite.bins, err = readBinManifest(newSuite.binDir); err != nil {
+		return err
+	}
+	md.Profiles = []string{profType}
+	pairs, err := listProfilePairs(oldSuite, newSuite, md)
+	if err != nil {
+		return err
+	}
+	p, err := selectProfilePair(pairs, pkg, bench)
+	if err != nil {
+		return errors.Wrapf(err, "run %s", md.Time.Format(timeFormat))
+	}
+
+	// A profile records the build ID of the binaries that it was recorded
+	// with, so pprof picks the binary of the matching ref from the search path.
+	binPath := strings.Join([]string{newSuite.binDir, oldSuite.binDir}, string(filepath.ListSeparator))
+	if err := os.Setenv("PPROF_BINARY_PATH", binPath); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s profile diff of %s against %s\n", p.name(), md.NewRef, md.OldRef)
+	return servePProf(ctx, []string{"-http=" + addr, "-diff_base=" + p.old, p.new})
+}
+
+// selectProfilePair selects the profiles of the benchmark identified by pkg and
+// bench, or the profiles of all profiled benchmarks if both are empty.
+func selectProfilePair(pairs []profilePair, pkg, bench string) (profilePair, error) {
+	rel := strings.TrimPrefix(pkg, "./")
+	var matches []profilePair
+	for _, p := range pairs {
+		if pkg == "" && bench == "" {
+			if p.bench == "" {
+				return p, nil
+			}
+			continue
+		}
+		if p.bench == "" {
+			continue
+		}
+		if pkg != "" && p.pkg != rel && !strings.HasSuffix(p.pkg, "/"+rel) {
+			continue
+		}
+		if bench != "" && p.bench != bench && p.bench != "Benchmark"+bench {
+			continue
+		}
+		matches = append(matches, p)
+	}
+	switch len(matches) {
+	case 0:
+		if pkg == "" && bench == "" {
+			return profilePair{}, errors.New("no profiles of both refs found")
+		}
+		return profilePair{}, errors.New("no profiles of matching benchmarks found; " +
+			"were they profiled with --profile-run?")
+	case 1:
+		return matches[0], nil
+	default:
+		names := make([]string, len(matches))
+		for i, p := range matches {
+			names[i] = p.name()
+		}
+		return profilePair{}, errors.Errorf("multiple benchmarks match, select one with --pkg and --bench: %s",
+			strings.Join(names, ", "))
+	}
+}
+
+// servePProf runs the pprof driver with the provided command-line arguments,
+// which serve its web UI until the context is canceled.
+func servePProf(ctx context.Context, args []string) error {
+	flags := newPProfFlags(args)
+	err := driver.PProf(&driver.Options{
+		Flagset: flags,
+		HTTPServer: func(a *driver.HTTPServerArgs) error {
+			return serveHTTP(ctx, a)
+		},
+	})
+	if flags.err != nil {
+		err = flags.err
+	}
+	return err
+}
+
+// serveHTTP serves the handlers of the pprof web UI under /ui/, like pprof
+// does, until the context is canceled.
+func serveHTTP(ctx context.Context, a *driver.HTTPServerArgs) error {
+	ln, err := net.Listen("tcp", a.Hostport)
+	if err != nil {
+		return err
+	}
+	ui := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, ok := a.Handlers[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/ui/", http.StripPrefix("/ui", ui))
+	mux.Handle("/", http.RedirectHandler("/ui/", http.StatusTemporaryRedirect))
+	s := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		_ = s.Close()
+	}()
+	if err := s.Serve(ln); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestSelectProfilePair(t *testing.T) {
+	all := profilePair{profType: "cpu", old: "old/cpu.prof", new: "new/cpu.prof"}
+	foo := profilePair{profType: "cpu", test: "example.com_sb_pkg_a", bench: "BenchmarkFoo", pkg: "example.com/sb/pkg/a"}
+	bar := profilePair{profType: "cpu", test: "example.com_sb_pkg_a", bench: "BenchmarkBar", pkg: "example.com/sb/pkg/a"}
+	baz := profilePair{profType: "cpu", test: "example.com_sb_pkg_b%5Fc", bench: "BenchmarkFoo", pkg: "example.com/sb/pkg/b_c"}
+	pairs := []profilePair{all, foo, bar, baz}
+
+	testCases := []struct {
+		pkg, bench string
+		exp        profilePair
+		err        bool
+	}{
+		{exp: all},
+		{pkg: "./pkg/a", bench: "BenchmarkFoo", exp: foo},
+		{pkg: "example.com/sb/pkg/a", bench: "Foo", exp: foo},
+		{bench: "Bar", exp: bar},
+		{pkg: "./pkg/b_c", exp: baz},
+		// Ambiguous.
+		{bench: "Foo", err: true},
+		{pkg: "./pkg/a", err: true},
+		// Not profiled with --profile-run.
+		{pkg: "./pkg/c", err: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.pkg+"/"+tc.bench, func(t *testing.T) {
+			p, err := selectProfilePair(pairs, tc.pkg, tc.bench)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected error, found %+v", p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p != tc.exp {
+				t.Errorf("expected %+v, found %+v", tc.exp, p)
+			}
+		})
+	}
+	if _, err := selectProfilePair([]profilePair{foo}, "", ""); err == nil {
+		t.Error("expected error without profiles of all benchmarks")
+	}
+}
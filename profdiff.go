@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/pprof/driver"
+	"github.com/pkg/errors"
+)
+
+// profileDiffTopN is the number of functions listed in the top reports of a
+// differential profile.
+const profileDiffTopN = 20
+
+// profileDiff is a differential profile of the new ref with the old ref as its
+// base, along with the reports that were generated from it. The paths of
+// reports that could not be generated are empty.
+type profileDiff struct {
+	profilePair
+	// top is the text report of the functions with the largest flat delta.
+	top string
+	// topPath and topCumPath are the text reports of the functions with the
+	// largest flat and cumulative delta.
+	topPath, topCumPath string
+	// svgPath is the call graph, which requires graphviz.
+	svgPath string
+	// flameGraphPath is an HTML flame graph.
+	flameGraphPath string
+}
+
+// files returns the kinds and paths of the reports of the differential profile.
+func (d profileDiff) files() [][2]string {
+	var fs [][2]string
+	for _, f := range [][2]string{
+		{"old profile", d.old},
+		{"new profile", d.new},
+		{"top", d.topPath},
+		{"top -cum", d.topCumPath},
+		{"svg", d.svgPath},
+		{"flame graph", d.flameGraphPath},
+	} {
+		if f[1] != "" {
+			fs = append(fs, f)
+		}
+	}
+	return fs
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// profilePair is a merged profile of the old and the new ref, of either all
+// profiled benchmarks or of a single benchmark selected by --profile-run.
+type profilePair struct {
+	profType string
+	// test and bench identify the benchmark of the profiles, if any, and
+	// pkg is the import path of the test binary's package.
+	test, bench, pkg string
+	old, new         string
+}
+
+// name describes the profiles, like "cpu" or "cpu BenchmarkFoo (pkg)".
+func (p profilePair) name() string {
+	if p.bench == "" {
+		return p.profType
+	}
+	return fmt.Sprintf("%s %s (%s)", p.profType, p.bench, p.pkg)
+}
+
+// listProfilePairs lists the merged profiles of the run of each of its profile
+// types that exist for both suites. Profile types that are not merged are
+// skipped.
+func listProfilePairs(oldSuite, newSuite *benchSuite, md runMetadata) ([]profilePair, error) {
+	var pairs []profilePair
+	for _, profType := range md.Profiles {
+		if pt, ok := lookupProfileType(profType); !ok || !pt.merged {
+			continue
+		}
+		p := profilePair{
+			profType: profType,
+			old:      oldSuite.getProfileFile(md.Time, profType),
+			new:      newSuite.getProfileFile(md.Time, profType),
+		}
+		if fileExists(p.old) && fileExists(p.new) {
+			pairs = append(pairs, p)
+		}
+		// The profiles of benchmarks selected by --profile-run.
+		matches, err := filepath.Glob(newSuite.getBenchProfileFile(md.Time, "*", "*", profType))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			dir, bench := filepath.Split(filepath.Dir(m))
+			test := filepath.Base(dir)
+			p := profilePair{
+				profType: profType,
+				test:     test,
+				bench:    bench,
+				pkg:      newSuite.testPkg(test),
+				old:      oldSuite.getBenchProfileFile(md.Time, test, bench, profType),
+				new:      m,
+			}
+			if fileExists(p.old) {
+				pairs = append(pairs, p)
+			}
+		}
+	}
+	return pairs, nil
+}
+
+// diffProfiles generates differential profile reports for each of the profile
+// types of the run whose merged profiles exist for both suites, including those
+// of the benchmarks selected by --profile-run. The reports are written next to
+// the new suite's profiles.
+func diffProfiles(oldSuite, newSuite *benchSuite, md runMetadata) ([]profileDiff, error) {
+	pairs, err := listProfilePairs(oldSuite, newSuite, md)
+	if err != nil {
+		return nil, err
+	}
+	var diffs []profileDiff
+	for _, p := range pairs {
+		d, err := diffProfile(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "generating %s profile diff", p.name())
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs, nil
+}
+
+func diffProfile(p profilePair) (profileDiff, error) {
+	d := profileDiff{profilePair: p}
+	base, prof := p.old, p.new
+	args := []string{"-symbolize=none", "-diff_base=" + base}
+	nodes := fmt.Sprintf("-nodecount=%d", profileDiffTopN)
+	// The reports are written next to the new profile, like cpu_diff.top.txt
+	// next to cpu.prof.
+	reportFile := func(ext string) string {
+		return filepath.Join(filepath.Dir(prof), p.profType+"_diff."+ext)
+	}
+
+	d.topPath = reportFile("top.txt")
+	if err := runPProf(append(args, "-top", nodes, "-output="+d.topPath, prof), nil); err != nil {
+		return d, err
+	}
+	top, err := os.ReadFile(d.topPath)
+	if err != nil {
+		return d, err
+	}
+	d.top = string(top)
+
+	d.topCumPath = reportFile("top_cum.txt")
+	if err := runPProf(append(args, "-top", "-cum", nodes, "-output="+d.topCumPath, prof), nil); err != nil {
+		return d, err
+	}
+
+	// The call graph is rendered by graphviz, which may not be installed.
+	if _, err := exec.LookPath("dot"); err == nil {
+		d.svgPath = reportFile("svg")
+		if err := runPProf(append(args, "-svg", "-output="+d.svgPath, prof), nil); err != nil {
+			return d, err
+		}
+	}
+
+	// pprof only renders flame graphs in its web UI. Capture the page instead
+	// of serving it.
+	var page bytes.Buffer
+	server := func(a *driver.HTTPServerArgs) error {
+		h, ok := a.Handlers["/flamegraph"]
+		if !ok {
+			return errors.New("pprof web UI has no flame graph")
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/flamegraph", nil))
+		if rec.Code != http.StatusOK {
+			return errors.Errorf("rendering flame graph: %s", strings.TrimSpace(rec.Body.String()))
+		}
+		_, err := io.Copy(&page, rec.Body)
+		return err
+	}
+	// The port is only used to form the UI's URL; nothing listens on it.
+	if err := runPProf(append(args, "-http=localhost:1", "-no_browser", prof), server); err != nil {
+		return d, err
+	}
+	d.flameGraphPath = reportFile("flamegraph.html")
+	if err := os.WriteFile(d.flameGraphPath, page.Bytes(), 0644); err != nil {
+		return d, err
+	}
+	return d, nil
+}
+
+// runPProf runs the pprof driver with the provided command-line arguments. The
+// driver's messages are included in the returned error.
+func runPProf(args []string, server func(*driver.HTTPServerArgs) error) error {
+	ui := &pprofUI{}
+	flags := newPProfFlags(args)
+	err := driver.PProf(&driver.Options{
+		Flagset:    flags,
+		UI:         ui,
+		HTTPServer: server,
+	})
+	if flags.err != nil {
+		err = flags.err
+	}
+	if err != nil {
+		return errors.Wrapf(err, "pprof %s: %s", strings.Join(args, " "), strings.TrimSpace(ui.errs.String()))
+	}
+	return nil
+}
+
+// pprofFlags implements driver.FlagSet, parsing a fixed set of arguments
+// instead of the command line.
+type pprofFlags struct {
+	*flag.FlagSet
+	args  []string
+	extra []string
+	err   error
+}
+
+func newPProfFlags(args []string) *pprofFlags {
+	fs := flag.NewFlagSet("pprof", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return &pprofFlags{FlagSet: fs, args: args}
+}
+
+func (f *pprofFlags) StringList(name, def, usage string) *[]*string {
+	return &[]*string{f.String(name, def, usage)}
+}
+
+func (f *pprofFlags) ExtraUsage() string {
+	return strings.Join(f.extra, "\n")
+}
+
+func (f *pprofFlags) AddExtraUsage(eu string) {
+	f.extra = append(f.extra, eu)
+}
+
+func (f *pprofFlags) Parse(usage func()) []string {
+	if f.err = f.FlagSet.Parse(f.args); f.err != nil {
+		return nil
+	}
+	return f.FlagSet.Args()
+}
+
+// pprofUI implements driver.UI non-interactively, collecting the driver's
+// error messages.
+type pprofUI struct {
+	errs bytes.Buffer
+}
+
+func (*pprofUI) ReadLine(string) (string, error)     { return "", io.EOF }
+func (*pprofUI) Print(...interface{})                {}
+func (*pprofUI) IsTerminal() bool                    { return false }
+func (*pprofUI) WantBrowser() bool                   { return false }
+func (*pprofUI) SetAutoComplete(func(string) string) {}
+
+func (ui *pprofUI) PrintErr(args ...interface{}) {
+	msg := fmt.Sprint(args...)
+	// Informational messages are printed as errors too.
+	if strings.HasPrefix(msg, "Generating report in ") {
+		return
+	}
+	ui.errs.WriteString(msg + "\n")
+}
+
+// formatProfileDiffsText writes the top functions of each differential profile
+// and the paths of its reports.
+func formatProfileDiffsText(w io.Writer, diffs []profileDiff) {
+	for _, d := range diffs {
+		fmt.Fprintf(w, "\n%s profile diff (new - old):\n%s", d.name(), d.top)
+		for _, f := range d.files() {
+			fmt.Fprintf(w, "  %s: %s\n", f[0], f[1])
+		}
+	}
+}
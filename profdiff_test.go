@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+func writeTestProfile(t *testing.T, path string, fooSamples, barSamples int64) {
+	t.Helper()
+	fn := []*profile.Function{
+		{ID: 1, Name: "pkg.foo"},
+		{ID: 2, Name: "pkg.bar"},
+	}
+	loc := []*profile.Location{
+		{ID: 1, Line: []profile.Line{{Function: fn[0]}}},
+		{ID: 2, Line: []profile.Line{{Function: fn[1]}}},
+	}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+		PeriodType: &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:     int64(10 * time.Millisecond),
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{loc[0]}, Value: []int64{fooSamples}},
+			{Location: []*profile.Location{loc[1]}, Value: []int64{barSamples}},
+		},
+		Location: loc,
+		Function: fn,
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := p.Write(f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiffProfiles(t *testing.T) {
+	dir := t.TempDir()
+	oldSuite := &benchSuite{artDir: filepath.Join(dir, "old")}
+	newSuite := &benchSuite{artDir: filepath.Join(dir, "new")}
+	// Two runs of the same refs. The regression is in pkg.foo in the first and
+	// in pkg.bar in the second.
+	t1 := time.Date(2021, 7, 20, 18, 47, 32, 0, time.UTC)
+	runs := []struct {
+		md  runMetadata
+		exp string
+	}{
+		// Profile types that were not recorded are skipped.
+		{md: runMetadata{Time: t1, Profiles: []string{"cpu", "mem"}}, exp: "pkg.foo"},
+		{md: runMetadata{Time: t1.Add(time.Hour), Profiles: []string{"cpu"}}, exp: "pkg.bar"},
+	}
+	for _, r := range runs {
+		for _, bs := range []*benchSuite{oldSuite, newSuite} {
+			if err := os.MkdirAll(bs.getProfileDir(r.md.Time), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		writeTestProfile(t, oldSuite.getProfileFile(r.md.Time, "cpu"), 1e9, 1e9)
+		if r.exp == "pkg.foo" {
+			writeTestProfile(t, newSuite.getProfileFile(r.md.Time, "cpu"), 3e9, 1e9)
+		} else {
+			writeTestProfile(t, newSuite.getProfileFile(r.md.Time, "cpu"), 1e9, 3e9)
+		}
+	}
+
+	for _, r := range runs {
+		diffs, err := diffProfiles(oldSuite, newSuite, r.md)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(diffs) != 1 || diffs[0].profType != "cpu" {
+			t.Fatalf("expected a cpu profile diff, found %+v", diffs)
+		}
+		d := diffs[0]
+		// The only difference is in one function, which should be listed
+		// first.
+		lines := strings.Split(d.top, "\n")
+		var first string
+		for i, l := range lines {
+			if strings.Contains(l, "flat%") && i+1 < len(lines) {
+				first = lines[i+1]
+				break
+			}
+		}
+		if !strings.Contains(first, r.exp) || !strings.Contains(first, "2s") {
+			t.Errorf("expected %s to have the largest delta, found top report:\n%s", r.exp, d.top)
+		}
+		for _, f := range d.files() {
+			if !fileExists(f[1]) {
+				t.Errorf("%s report %s not written", f[0], f[1])
+			}
+			if !strings.HasPrefix(f[1], newSuite.getProfileDir(r.md.Time)) &&
+				!strings.HasPrefix(f[1], oldSuite.getProfileDir(r.md.Time)) {
+				t.Errorf("%s report %s not in the run's profile directory", f[0], f[1])
+			}
+		}
+		if d.flameGraphPath == "" {
+			t.Error("expected a flame graph")
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+)
+
+// profileType is a kind of profile that test binaries can record.
+type profileType struct {
+	// name identifies the profile type in file names and in run manifests.
+	name string
+	// flag is the name of the benchdiff flag that enables the profile type.
+	flag string
+	// testFlag is the test binary flag that writes the profile to a file.
+	testFlag string
+	// merged is set if the profiles of all iterations are merged into one.
+	// Otherwise, the profile of each iteration is kept in a file of its own.
+	merged bool
+}
+
+// profileTypes is the registry of all supported profile types.
+var profileTypes = []profileType{
+	{name: "cpu", flag: "cpuprofile", testFlag: "-test.cpuprofile", merged: true},
+	{name: "mem", flag: "memprofile", testFlag: "-test.memprofile", merged: true},
+	{name: "mutex", flag: "mutexprofile", testFlag: "-test.mutexprofile", merged: true},
+	{name: "block", flag: "blockprofile", testFlag: "-test.blockprofile", merged: true},
+	{name: "trace", flag: "trace", testFlag: "-test.trace"},
+}
+
+// lookupProfileType returns the profile type with the provided name.
+func lookupProfileType(name string) (profileType, bool) {
+	for _, pt := range profileTypes {
+		if pt.name == name {
+			return pt, true
+		}
+	}
+	return profileType{}, false
+}
+
+// profileOptions configures which profiles test binaries record.
+type profileOptions struct {
+	// types are the enabled profile types.
+	types []profileType
+	// memRate, if non-zero, is passed as -test.memprofilerate.
+	memRate int
+	// blockRate, if non-zero, is passed as -test.blockprofilerate.
+	blockRate int
+}
+
+func (o profileOptions) enabled(name string) bool {
+	for _, pt := range o.types {
+		if pt.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// names returns the names of the enabled profile types.
+func (o profileOptions) names() []string {
+	names := make([]string, len(o.types))
+	for i, pt := range o.types {
+		names[i] = pt.name
+	}
+	return names
+}
+
+// merged returns the enabled profile types whose profiles are merged.
+func (o profileOptions) merged() []profileType {
+	var pts []profileType
+	for _, pt := range o.types {
+		if pt.merged {
+			pts = append(pts, pt)
+		}
+	}
+	return pts
+}
+
+// args returns the test binary flags that record the enabled profiles of an
+// invocation of the test binary. Merged profiles are written to the suite's
+// last profile files, from which they are merged, and the others to the files
+// of the iteration of the provided unit.
+func (o profileOptions) args(bs *benchSuite, test string, u benchUnit, iter int) []string {
+	var args []string
+	for _, pt := range o.types {
+		path := bs.getIterProfileFile(test, u, iter, pt.name)
+		if pt.merged {
+			path = bs.getLastProfileFile(test, pt.name)
+		}
+		args = append(args, pt.testFlag, path)
+	}
+	if o.memRate != 0 && o.enabled("mem") {
+		args = append(args, "-test.memprofilerate", strconv.Itoa(o.memRate))
+	}
+	if o.blockRate != 0 && o.enabled("block") {
+		args = append(args, "-test.blockprofilerate", strconv.Itoa(o.blockRate))
+	}
+	return args
+}
+
+// getIterProfileFile returns the path of the profile of a single iteration of
+// the unit, for profile types whose profiles are not merged.
+func (bs *benchSuite) getIterProfileFile(test string, u benchUnit, iter int, profType string) string {
+	if u.profile {
+		return filepath.Join(bs.artDir, test, u.name, profType+"."+strconv.Itoa(iter)+".out")
+	}
+	name := profType + "." + test
+	if u.name != "" {
+		name += "." + u.name
+	}
+	return filepath.Join(bs.artDir, name+"."+strconv.Itoa(iter)+".out")
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProfileOptionsArgs(t *testing.T) {
+	lookup := func(names ...string) []profileType {
+		var pts []profileType
+		for _, n := range names {
+			pt, ok := lookupProfileType(n)
+			if !ok {
+				t.Fatalf("unknown profile type %q", n)
+			}
+			pts = append(pts, pt)
+		}
+		return pts
+	}
+	bs := &benchSuite{artDir: "art"}
+	testCases := []struct {
+		name string
+		opts profileOptions
+		unit benchUnit
+		exp  []string
+	}{
+		{
+			name: "none",
+		},
+		{
+			name: "merged",
+			opts: profileOptions{types: lookup("cpu", "block"), blockRate: 100, memRate: 1},
+			exp: []string{
+				"-test.cpuprofile", "art/cpu_last.pkg.prof",
+				"-test.blockprofile", "art/block_last.pkg.prof",
+				"-test.blockprofilerate", "100",
+			},
+		},
+		{
+			name: "trace",
+			opts: profileOptions{types: lookup("mem", "trace"), memRate: 1},
+			unit: benchUnit{name: "BenchmarkFoo"},
+			exp: []string{
+				"-test.memprofile", "art/mem_last.pkg.prof",
+				"-test.trace", "art/trace.pkg.BenchmarkFoo.3.out",
+				"-test.memprofilerate", "1",
+			},
+		},
+		{
+			name: "profile-run",
+			opts: profileOptions{types: lookup("trace")},
+			unit: benchUnit{name: "BenchmarkFoo", profile: true},
+			exp:  []string{"-test.trace", "art/pkg/BenchmarkFoo/trace.3.out"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := tc.opts.args(bs, "pkg", tc.unit, 3)
+			if !reflect.DeepEqual(args, tc.exp) {
+				t.Errorf("expected %q, found %q", tc.exp, args)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+const runsUsage = `usage: benchdiff runs`
+
+const runsHelpString = `benchdiff runs lists the previous benchmark runs found in the benchdiff
+artifacts directories, most recent first. Each run can be reprocessed by passing
+its index, its time, or 'latest' to --previous-run.
+
+Options:
+      --help                display this help`
+
+// prevRun is a previous benchmark run found in the artifacts directories.
+type prevRun struct {
+	md runMetadata
+	// hasManifest is false for runs that predate run manifests. The refs
+	// and subjects of such runs are inferred from their output files and
+	// the git history, and the remainder of md is left empty.
+	hasManifest bool
+	// iters is the number of iterations that were run for each package, as
+	// determined from the output files.
+	iters int
+}
+
+func runListRuns(ctx context.Context, args []string) error {
+	var help bool
+	flags := pflag.NewFlagSet("runs", pflag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, runsUsage) }
+	flags.BoolVarP(&help, "help", "h", false, "")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if help || flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, runsUsage)
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, runsHelpString)
+		return nil
+	}
+
+	runs, err := findPrevRuns(ctx)
+	if err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		return errors.New("no previous runs found")
+	}
+	printPrevRuns(os.Stdout, runs)
+	return nil
+}
+
+// printPrevRuns prints the list of previous runs as a table, like:
+//
+//	idx  time                       old      new      pkgs     iters  subject
+//	0    2021-07-21T09_12_03-04:00  efcf66c  6299bd4  ./pkg/…  10     Speed up thing
+func printPrevRuns(w io.Writer, runs []prevRun) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "idx\ttime\told\tnew\tpkgs\titers\tsubject")
+	for i, r := range runs {
+		pkgs := strings.Join(r.md.PkgFilter, " ")
+		if !r.hasManifest {
+			pkgs = "?"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%.40s\t%d\t%.50s\n",
+			i, r.md.Time.Format(timeFormat), r.md.OldRef, r.md.NewRef, pkgs, r.iters, r.md.NewSubject)
+	}
+	_ = tw.Flush()
+}
+
+// findPrevRun finds the previous run identified by sel, which is either the
+// time of the run in timeFormat, an index into the list of runs printed by
+// `benchdiff runs`, or "latest".
+func findPrevRun(ctx context.Context, sel string) (prevRun, error) {
+	runs, err := findPrevRuns(ctx)
+	if err != nil {
+		return prevRun{}, err
+	}
+	if len(runs) == 0 {
+		return prevRun{}, errors.New("no previous runs found")
+	}
+	if sel == "latest" {
+		return runs[0], nil
+	}
+	if idx, err := strconv.Atoi(sel); err == nil {
+		if idx < 0 || idx >= len(runs) {
+			return prevRun{}, errors.Errorf("previous run index %d out of range [0,%d)", idx, len(runs))
+		}
+		return runs[idx], nil
+	}
+	t, err := time.Parse(timeFormat, sel)
+	if err != nil {
+		return prevRun{}, errors.Wrap(err, "parsing previous run")
+	}
+	for _, r := range runs {
+		if r.md.Time.Equal(t) {
+			return r, nil
+		}
+	}
+	return prevRun{}, errors.Errorf("no previous run at %s", sel)
+}
+
+// findPrevRuns scans the artifacts directories of all refs for previous runs.
+// The runs are returned most recent first.
+func findPrevRuns(ctx context.Context) ([]prevRun, error) {
+	artDirs, err := filepath.Glob(filepath.Join(benchdiffDir, "*", "artifacts"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Group output files by the time of their run.
+	type runFiles struct {
+		manifest string
+		outFiles map[string]string // ref -> path
+	}
+	byTime := make(map[string]*runFiles)
+	for _, artDir := range artDirs {
+		ref := filepath.Base(filepath.Dir(artDir))
+		files, err := os.ReadDir(artDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			name := f.Name()
+			var ts string
+			isManifest := false
+			switch {
+			case strings.HasPrefix(name, "out."):
+				ts = strings.TrimPrefix(name, "out.")
+			case strings.HasPrefix(name, "run.") && strings.HasSuffix(name, ".json"):
+				ts = strings.TrimSuffix(strings.TrimPrefix(name, "run."), ".json")
+				isManifest = true
+			default:
+				continue
+			}
+			rf, ok := byTime[ts]
+			if !ok {
+				rf = &runFiles{outFiles: make(map[string]string)}
+				byTime[ts] = rf
+			}
+			path := filepath.Join(artDir, name)
+			if isManifest {
+				rf.manifest = path
+			} else {
+				rf.outFiles[ref] = path
+			}
+		}
+	}
+
+	var runs []prevRun
+	for ts, rf := range byTime {
+		t, err := time.Parse(timeFormat, ts)
+		if err != nil {
+			// Not a benchdiff output file.
+			continue
+		}
+		var r prevRun
+		if rf.manifest != "" {
+			if r.md, err = readManifest(rf.manifest); err != nil {
+				return nil, errors.Wrapf(err, "reading run manifest %s", rf.manifest)
+			}
+			r.hasManifest = true
+		} else {
+			if len(rf.outFiles) != 2 {
+				// Without a manifest, we can only make sense of runs that
+				// compared exactly two refs.
+				continue
+			}
+			var refs []string
+			for ref := range rf.outFiles {
+				refs = append(refs, ref)
+			}
+			r.md.Time = t
+			r.md.OldRef, r.md.NewRef = inferRefOrder(ctx, refs[0], refs[1])
+			r.md.OldSubject, _ = subjectForRef(ctx, r.md.OldRef)
+			r.md.NewSubject, _ = subjectForRef(ctx, r.md.NewRef)
+		}
+		if path, ok := rf.outFiles[r.md.NewRef]; ok {
+			if r.iters, err = countIterations(path); err != nil {
+				return nil, err
+			}
+		}
+		runs = append(runs, r)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].md.Time.After(runs[j].md.Time)
+	})
+	return runs, nil
+}
+
+// inferRefOrder determines which of the two refs is the old ref and which is
+// the new ref. If one is an ancestor of the other, it is the old ref.
+// Otherwise, the ref with the older commit time is considered the old ref.
+func inferRefOrder(ctx context.Context, a, b string) (oldRef, newRef string) {
+	if isAncestor(ctx, b, a) {
+		return b, a
+	}
+	if isAncestor(ctx, a, b) {
+		return a, b
+	}
+	aTime, _ := capture(ctx, "git", "log", "-1", "--format=%ct", a)
+	bTime, _ := capture(ctx, "git", "log", "-1", "--format=%ct", b)
+	aSecs, _ := strconv.ParseInt(aTime, 10, 64)
+	bSecs, _ := strconv.ParseInt(bTime, 10, 64)
+	if bSecs < aSecs {
+		return b, a
+	}
+	return a, b
+}
+
+// countIterations determines how many iterations a benchmark output file
+// contains. Each iteration produces one result per benchmark, so the number of
+// iterations of a package is the largest number of results of any of its
+// benchmarks. This holds regardless of whether the benchmarks were run one
+// package or one benchmark at a time. If the run was cut short, the smallest
+// number of iterations across packages is returned.
+func countIterations(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	counts := make(map[string]map[string]int) // pkg -> benchmark -> results
+	var pkg string
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		line := s.Text()
+		if p := strings.TrimPrefix(line, "pkg: "); p != line {
+			pkg = p
+			if counts[pkg] == nil {
+				counts[pkg] = make(map[string]int)
+			}
+			continue
+		}
+		// Result lines contain the number of iterations and the time per
+		// iteration, unlike the names of failed or panicking benchmarks.
+		fields := strings.Fields(line)
+		if len(fields) >= 4 && strings.HasPrefix(fields[0], "Benchmark") && counts[pkg] != nil {
+			counts[pkg][fields[0]]++
+		}
+	}
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+	iters := -1
+	for _, benches := range counts {
+		pkgIters := 0
+		for _, c := range benches {
+			if c > pkgIters {
+				pkgIters = c
+			}
+		}
+		if iters == -1 || pkgIters < iters {
+			iters = pkgIters
+		}
+	}
+	if iters == -1 {
+		iters = 0
+	}
+	return iters, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCountIterations(t *testing.T) {
+	const out = `goos: linux
+goarch: amd64
+pkg: example.com/a
+BenchmarkFoo-8   	1000	      1000 ns/op
+PASS
+goos: linux
+goarch: amd64
+pkg: example.com/b
+BenchmarkBar-8   	1000	      1000 ns/op
+PASS
+goos: linux
+goarch: amd64
+pkg: example.com/a
+BenchmarkFoo-8   	1000	      1000 ns/op
+PASS
+`
+	path := filepath.Join(t.TempDir(), "out")
+	if err := os.WriteFile(path, []byte(out), 0644); err != nil {
+		t.Fatal(err)
+	}
+	iters, err := countIterations(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Package b was only run once, so the run is considered to have a single
+	// complete iteration.
+	if iters != 1 {
+		t.Fatalf("expected 1 iteration, found %d", iters)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// watchdogInterval is how often the watchdog checks on a test binary.
+const watchdogInterval = time.Second
+
+// watchdog monitors a running test binary. If the binary runs for longer than
+// its timeout or stops producing output for longer than its hang timeout, the
+// watchdog considers it hung. It then sends the binary a SIGQUIT, which causes
+// the Go runtime to dump the stacks of all goroutines and exit, and redirects
+// the remainder of the binary's output, i.e. the goroutine dump, to a file.
+//
+// The watchdog is an io.Writer that the binary's output must be written to.
+type watchdog struct {
+	timeout, hangTimeout time.Duration
+
+	mu struct {
+		sync.Mutex
+		w         io.Writer
+		lastWrite time.Time
+		// partial is the last incomplete line of output. When a benchmark is
+		// not run in verbose mode, the testing package prints the benchmark's
+		// name before running it, so this identifies the running benchmark.
+		partial []byte
+	}
+}
+
+func newWatchdog(w io.Writer, timeout, hangTimeout time.Duration) *watchdog {
+	wd := &watchdog{timeout: timeout, hangTimeout: hangTimeout}
+	wd.mu.w = w
+	wd.mu.lastWrite = time.Now()
+	return wd
+}
+
+func (wd *watchdog) Write(p []byte) (int, error) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	wd.mu.lastWrite = time.Now()
+	if i := bytes.LastIndexByte(p, '\n'); i >= 0 {
+		wd.mu.partial = append(wd.mu.partial[:0], p[i+1:]...)
+	} else if len(wd.mu.partial) < 1024 {
+		wd.mu.partial = append(wd.mu.partial, p...)
+	}
+	return wd.mu.w.Write(p)
+}
+
+func (wd *watchdog) redirect(w io.Writer) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	wd.mu.w = w
+}
+
+func (wd *watchdog) sinceLastWrite() time.Duration {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	return time.Since(wd.mu.lastWrite)
+}
+
+// runningBenchmark returns the name of the benchmark that the test binary was
+// running when it last produced output, without its GOMAXPROCS suffix. It
+// returns false if the name could not be determined.
+func (wd *watchdog) runningBenchmark() (string, bool) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	fields := strings.Fields(string(wd.mu.partial))
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "Benchmark") {
+		return "", false
+	}
+	return trimProcsSuffix(fields[0]), true
+}
+
+// benchFrameRE matches a benchmark function in a goroutine stack trace, like:
+//
+//	example.com/pkg.BenchmarkFoo(0xc0001a2000)
+var benchFrameRE = regexp.MustCompile(`(?m)^\S+\.(Benchmark[^.(\s]*)\(`)
+
+// benchmarkFromDump returns the name of the benchmark function found in the
+// goroutine dump at path. The testing package does not print a benchmark's
+// name until the benchmark has run once, so a benchmark that hangs on its
+// first run can only be identified this way.
+func benchmarkFromDump(path string) (string, bool) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	m := benchFrameRE.FindSubmatch(b)
+	if m == nil {
+		return "", false
+	}
+	return string(m[1]), true
+}
+
+// trimProcsSuffix strips the "-<GOMAXPROCS>" suffix that the testing package
+// appends to benchmark names.
+func trimProcsSuffix(name string) string {
+	i := strings.LastIndexByte(name, '-')
+	if i < 0 {
+		return name
+	}
+	for _, c := range name[i+1:] {
+		if c < '0' || c > '9' {
+			return name
+		}
+	}
+	if i+1 == len(name) {
+		return name
+	}
+	return name[:i]
+}
+
+// run starts the command and waits for it to exit. The command's stdout and
+// stderr must already be directed to the watchdog. If the watchdog detects
+// that the command is hung, the command's goroutine dump is written to
+// dumpPath and run returns a description of why the command was considered
+// hung alongside the command's exit error.
+func (wd *watchdog) run(cmd *exec.Cmd, dumpPath string) (hung string, _ error) {
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	if wd.timeout == 0 && wd.hangTimeout == 0 {
+		return "", <-done
+	}
+
+	start := time.Now()
+	t := time.NewTicker(watchdogInterval)
+	defer t.Stop()
+	for {
+		select {
+		case err := <-done:
+			return hung, err
+		case <-t.C:
+		}
+		if hung != "" {
+			continue
+		}
+		switch {
+		case wd.timeout > 0 && time.Since(start) > wd.timeout:
+			hung = fmt.Sprintf("timed out after %s", wd.timeout)
+		case wd.hangTimeout > 0 && wd.sinceLastWrite() > wd.hangTimeout:
+			hung = fmt.Sprintf("produced no output for %s", wd.hangTimeout)
+		default:
+			continue
+		}
+
+		f, err := os.Create(dumpPath)
+		if err != nil {
+			_ = cmd.Process.Kill()
+			<-done
+			return hung, err
+		}
+		defer f.Close()
+		wd.redirect(f)
+		if err := cmd.Process.Signal(syscall.SIGQUIT); err != nil {
+			_ = cmd.Process.Kill()
+		}
+		// If the binary does not exit after dumping its goroutines, kill it.
+		killer := time.AfterFunc(cancelGracePeriod, func() { _ = cmd.Process.Kill() })
+		defer killer.Stop()
+	}
+}
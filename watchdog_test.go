@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWatchdogRunningBenchmark(t *testing.T) {
+	for _, tc := range []struct {
+		out  string
+		name string
+		ok   bool
+	}{
+		{"", "", false},
+		{"goos: linux\npkg: example.com/a\n", "", false},
+		{"pkg: example.com/a\nBenchmarkFoo-8   \t", "BenchmarkFoo", true},
+		{"BenchmarkFoo-8 \t 100\t 10 ns/op\nBenchmarkBar/n=10-16", "BenchmarkBar/n=10", true},
+		{"BenchmarkBaz/size-x", "BenchmarkBaz/size-x", true},
+	} {
+		var buf bytes.Buffer
+		wd := newWatchdog(&buf, 0, 0)
+		if _, err := wd.Write([]byte(tc.out)); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tc.out {
+			t.Errorf("%q: output not forwarded, got %q", tc.out, buf.String())
+		}
+		name, ok := wd.runningBenchmark()
+		if name != tc.name || ok != tc.ok {
+			t.Errorf("%q: expected (%q, %t), found (%q, %t)", tc.out, tc.name, tc.ok, name, ok)
+		}
+	}
+}
+
+func TestBenchmarkFromDump(t *testing.T) {
+	const dump = `SIGQUIT: quit
+PC=0x46d2a1 m=0 sigcode=0
+
+goroutine 6 [sleep]:
+time.Sleep(0x34630b8a000)
+	/usr/local/go/src/runtime/time.go:300 +0xf2
+example.com/sb/pkg/a.BenchmarkHang(0xc000130008?)
+	/tmp/sb/pkg/a/hang_test.go:8 +0x1d
+testing.(*B).runN(0xc000130008, 0x1)
+`
+	path := filepath.Join(t.TempDir(), "hang.txt")
+	if err := os.WriteFile(path, []byte(dump), 0644); err != nil {
+		t.Fatal(err)
+	}
+	name, ok := benchmarkFromDump(path)
+	if !ok || name != "BenchmarkHang" {
+		t.Errorf("expected BenchmarkHang, found (%q, %t)", name, ok)
+	}
+}